@@ -0,0 +1,104 @@
+package sx
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is an amount of bytes, it's represented in sx as a number with an
+// optional unit suffix: 512, 1.5kB, 256MiB. Both decimal (kB, MB, GB, ...)
+// and binary (KiB, MiB, GiB, ...) units are supported.
+type ByteSize uint64
+
+const (
+	Byte ByteSize = 1
+
+	KB ByteSize = 1000 * Byte
+	MB          = 1000 * KB
+	GB          = 1000 * MB
+	TB          = 1000 * GB
+	PB          = 1000 * TB
+	EB          = 1000 * PB
+
+	KiB ByteSize = 1 << (10 * (iota - 6))
+	MiB
+	GiB
+	TiB
+	PiB
+	EiB
+)
+
+var byteSizeUnits = []struct {
+	name string
+	size ByteSize
+}{
+	// sorted by size, "kB" is preferred over "KB" when formatting
+	{"EiB", EiB}, {"EB", EB}, {"PiB", PiB}, {"PB", PB}, {"TiB", TiB}, {"TB", TB},
+	{"GiB", GiB}, {"GB", GB}, {"MiB", MiB}, {"MB", MB}, {"KiB", KiB}, {"kB", KB}, {"KB", KB},
+	{"B", Byte},
+}
+
+// ParseByteSize parses a number with an optional unit suffix. A fractional
+// number is allowed as long as the result is a whole number of bytes.
+func ParseByteSize(s string) (ByteSize, error) {
+	num, unit := s, Byte
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.name) {
+			num, unit = s[:len(s)-len(u.name)], u.size
+			break
+		}
+	}
+	if num == "" || num[0] < '0' || num[0] > '9' {
+		return 0, errors.New("invalid byte size: " + s)
+	}
+
+	if n, err := strconv.ParseUint(num, 10, 64); err == nil {
+		if n > math.MaxUint64/uint64(unit) {
+			return 0, errors.New("byte size overflow: " + s)
+		}
+		return ByteSize(n) * unit, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, errors.New("invalid byte size: " + s)
+	}
+	f *= float64(unit)
+	if f >= math.MaxUint64 {
+		return 0, errors.New("byte size overflow: " + s)
+	}
+	if f != math.Trunc(f) {
+		return 0, errors.New("byte size is not a whole number of bytes: " + s)
+	}
+	return ByteSize(f), nil
+}
+
+// String returns the size using the biggest unit which represents it exactly.
+func (b ByteSize) String() string {
+	if b == 0 {
+		return "0"
+	}
+	for _, u := range byteSizeUnits {
+		if b%u.size == 0 {
+			return strconv.FormatUint(uint64(b/u.size), 10) + u.name
+		}
+	}
+	panic("unreachable")
+}
+
+func (b *ByteSize) UnmarshalSX(tree []Node) error {
+	if !isTreeScalar(tree) {
+		return errors.New("scalar node expected")
+	}
+	v, err := ParseByteSize(tree[0].Value)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+func (b ByteSize) MarshalSX() ([]Node, error) {
+	return []Node{{Value: b.String()}}, nil
+}
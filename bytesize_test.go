@@ -0,0 +1,54 @@
+package sx
+
+import (
+	"testing"
+)
+
+var byteSizeCases = []struct {
+	input    string
+	expected ByteSize
+	valid    bool
+	output   string
+}{
+	{"0", 0, true, "0"},
+	{"512", 512, true, "512B"},
+	{"512B", 512, true, "512B"},
+	{"1kB", 1000, true, "1kB"},
+	{"1KB", 1000, true, "1kB"},
+	{"1.5kB", 1500, true, "1500B"},
+	{"256MiB", 256 * MiB, true, "256MiB"},
+	{"1.5GiB", 1536 * MiB, true, "1536MiB"},
+	{"1024KiB", MiB, true, "1MiB"},
+	{"2000MB", 2 * GB, true, "2GB"},
+	{"16EiB", 0, false, ""},
+	{"15EiB", 15 * EiB, true, "15EiB"},
+	{"1.5B", 0, false, ""},
+	{"-1MiB", 0, false, ""},
+	{"MiB", 0, false, ""},
+	{"10 MiB", 0, false, ""},
+	{"10mib", 0, false, ""},
+	{"", 0, false, ""},
+}
+
+func TestByteSize(t *testing.T) {
+	for i, c := range byteSizeCases {
+		b, err := ParseByteSize(c.input)
+		if err != nil && c.valid {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if err == nil && !c.valid {
+			t.Errorf("case %d, expected an error", i)
+			continue
+		}
+		if !c.valid {
+			continue
+		}
+		if b != c.expected {
+			t.Errorf("case %d, got %d, expected %d", i, b, c.expected)
+		}
+		if s := b.String(); s != c.output {
+			t.Errorf("case %d, got %q, expected %q", i, s, c.output)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package sx
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//----------------------------------------------------------------------------
+// concrete syntax tree
+//----------------------------------------------------------------------------
+
+// Trivia is a run of space characters or a single comment, it holds the
+// source text as is.
+type Trivia struct {
+	Comment bool
+	Text    string
+}
+
+// CSTNode is a node of a concrete syntax tree. Unlike Node it keeps
+// everything needed to print the source back byte for byte: the literal text
+// of scalars, comments and spaces around nodes.
+type CSTNode struct {
+	Kind  Kind
+	Value string     // decoded value of a scalar node
+	Text  string     // source text of a scalar node
+	List  []*CSTNode // elements of a list node
+
+	Leading  []Trivia // spaces and comments before the node
+	Trailing []Trivia // spaces and a comment after the node, on the same line
+	Inner    []Trivia // spaces and comments before the closing ')' of a list
+
+	// Positions are not updated when the tree is modified.
+	Start Pos
+	End   Pos
+}
+
+// CST is a concrete syntax tree of an sx document.
+type CST struct {
+	Nodes    []*CSTNode
+	Trailing []Trivia // spaces and comments after the last node
+}
+
+func (n *CSTNode) IsScalar() bool {
+	return n.Kind != List
+}
+
+// SetValue changes the value of a scalar node. The node keeps its literal
+// form if the new value allows it, otherwise the simplest one is used.
+func (n *CSTNode) SetValue(value string) {
+	p := printer{prefix: lineIndent(n.Leading)}
+	p.writeValue(Node{Value: value, Kind: n.Kind}, 0)
+	n.Value = value
+	n.Text = p.buf.String()
+	switch {
+	case strings.HasPrefix(n.Text, "`\n"):
+		n.Kind = MultiLine
+	case n.Text[0] == '`':
+		n.Kind = RawString
+	case n.Text[0] == '"':
+		n.Kind = String
+	default:
+		n.Kind = Scalar
+	}
+}
+
+// Node converts a CST node to an AST node.
+func (n *CSTNode) Node() Node {
+	if n.Kind != List {
+		return Node{Value: n.Value, Kind: n.Kind, Start: n.Start, End: n.End}
+	}
+	list := make([]Node, len(n.List))
+	for i, elem := range n.List {
+		list[i] = elem.Node()
+	}
+	return Node{List: list, Kind: List, Start: n.Start, End: n.End}
+}
+
+// AST converts a concrete syntax tree to a list of AST nodes, the result is
+// the same as Parse would return.
+func (c *CST) AST() []Node {
+	var out []Node
+	for _, n := range c.Nodes {
+		out = append(out, n.Node())
+	}
+	return out
+}
+
+func writeTrivia(buf *bytes.Buffer, trivia []Trivia) {
+	for _, t := range trivia {
+		buf.WriteString(t.Text)
+	}
+}
+
+func (n *CSTNode) write(buf *bytes.Buffer) {
+	writeTrivia(buf, n.Leading)
+	if n.Kind == List {
+		buf.WriteByte('(')
+		for _, elem := range n.List {
+			elem.write(buf)
+		}
+		writeTrivia(buf, n.Inner)
+		buf.WriteByte(')')
+	} else {
+		buf.WriteString(n.Text)
+	}
+	writeTrivia(buf, n.Trailing)
+}
+
+// Bytes prints the tree. Printing of an unmodified tree gives back the source
+// it was parsed from.
+func (c *CST) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, n := range c.Nodes {
+		n.write(&buf)
+	}
+	writeTrivia(&buf, c.Trailing)
+	return buf.Bytes()
+}
+
+// Returns indentation of the line where a node with the given leading trivia
+// starts, assuming the node is the first one on its line.
+func lineIndent(leading []Trivia) string {
+	if len(leading) == 0 {
+		return ""
+	}
+	last := leading[len(leading)-1]
+	if last.Comment {
+		return ""
+	}
+	i := strings.LastIndexByte(last.Text, '\n')
+	if i == -1 {
+		return ""
+	}
+	return last.Text[i+1:]
+}
+
+//----------------------------------------------------------------------------
+// concrete syntax tree parser
+//----------------------------------------------------------------------------
+
+// Expects pointer anywhere, leaves pointer at the first non-space character
+// which doesn't belong to a comment.
+func (p *parser) parseTrivia() []Trivia {
+	var out []Trivia
+	for {
+		start := p.ptr
+		switch b := p.current(); {
+		case b == ';':
+			p.skipComment()
+			out = append(out, Trivia{Comment: true, Text: string(p.data[start:p.ptr])})
+		case b != eof && isSpace(b):
+			p.skipToNonSpace()
+			out = append(out, Trivia{Text: string(p.data[start:p.ptr])})
+		default:
+			return out
+		}
+	}
+}
+
+// Parses spaces followed by a comment on the same line. Leaves pointer
+// untouched if there is no such comment.
+func (p *parser) parseTrailingTrivia() []Trivia {
+	start := p.ptr
+	for b := p.current(); b == ' ' || b == '\t' || b == '\r'; b = p.advance() {
+	}
+	if p.current() != ';' {
+		// no new lines were skipped, it's safe to go back
+		p.ptr = start
+		return nil
+	}
+
+	var out []Trivia
+	if p.ptr != start {
+		out = append(out, Trivia{Text: string(p.data[start:p.ptr])})
+	}
+	start = p.ptr
+	p.skipComment()
+	return append(out, Trivia{Comment: true, Text: string(p.data[start:p.ptr])})
+}
+
+// Expects pointer at the beginning of a node, spaces and comments are
+// skipped already.
+func (p *parser) parseCSTNode() (*CSTNode, bool) {
+	if p.current() == '(' {
+		return p.parseCSTList()
+	}
+	node, ok := p.parseSingleNode()
+	if !ok {
+		return nil, false
+	}
+	return &CSTNode{
+		Kind:  node.Kind,
+		Value: node.Value,
+		Text:  string(p.data[node.Start.Offset-p.base : node.End.Offset-p.base]),
+		Start: node.Start,
+		End:   node.End,
+	}, true
+}
+
+// Expects pointer at opening '(', leaves pointer at the next character after
+// closing ')'.
+func (p *parser) parseCSTList() (*CSTNode, bool) {
+	defer p.leaveList()
+	if !p.enterList() {
+		return nil, false
+	}
+	n := &CSTNode{Kind: List, List: []*CSTNode{}, Start: p.pos()}
+	p.advance() // skip opening '('
+	for {
+		leading := p.parseTrivia()
+		switch p.current() {
+		case eof:
+			p.eofError(fmt.Sprintf("unexpected eof when parsing a list opened at %s", n.Start))
+			return nil, false
+		case ')':
+			p.advance()
+			n.Inner = leading
+			n.End = p.pos()
+			return n, true
+		default:
+			elem, ok := p.parseCSTNode()
+			if !ok {
+				return nil, false
+			}
+			elem.Leading = leading
+			elem.Trailing = p.parseTrailingTrivia()
+			n.List = append(n.List, elem)
+		}
+	}
+}
+
+func (p *parser) parseCST() *CST {
+	c := &CST{}
+	for {
+		leading := p.parseTrivia()
+		if p.current() == eof {
+			c.Trailing = leading
+			return c
+		}
+		n, ok := p.parseCSTNode()
+		if !ok {
+			return nil
+		}
+		n.Leading = leading
+		n.Trailing = p.parseTrailingTrivia()
+		c.Nodes = append(c.Nodes, n)
+	}
+}
+
+// ParseCST parses sx data into a concrete syntax tree, which preserves
+// comments, spaces and literal forms. On failure the error is a *SyntaxError,
+// or a *LimitError if lists are nested too deep.
+func ParseCST(data []byte) (*CST, error) {
+	p := newParser(data)
+	c := p.parseCST()
+	if p.err != nil {
+		return nil, p.err
+	}
+	return c, nil
+}
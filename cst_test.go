@@ -0,0 +1,91 @@
+package sx
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestCSTRoundTrip(t *testing.T) {
+	inputs := []string{}
+	for _, c := range cases {
+		if c.valid {
+			inputs = append(inputs, c.input)
+		}
+	}
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs = append(inputs, string(data),
+		"(a ; one\n  b ; two\r\n  ; three\n\n) ; four\n; five",
+		"(a\t`raw`\t\"\\x41\" `\n\t| multi\n\t`)  ",
+	)
+
+	for i, input := range inputs {
+		c, err := ParseCST([]byte(input))
+		if err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if out := string(c.Bytes()); out != input {
+			t.Errorf("case %d\ngot:\n%q\nexpected:\n%q", i, out, input)
+		}
+		expected, _ := Parse([]byte(input))
+		if ast := c.AST(); !reflect.DeepEqual(ast, expected) {
+			t.Errorf("case %d, AST doesn't match\ngot:\n%s\nexpected:\n%s", i, prettyPrint(ast), prettyPrint(expected))
+		}
+	}
+
+	for i, c := range cases {
+		if c.valid {
+			continue
+		}
+		_, err := ParseCST([]byte(c.input))
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("case %d, expected a syntax error, got: %v", i, err)
+		}
+	}
+}
+
+func TestCSTComments(t *testing.T) {
+	input := "; header\n\n(a 1) ; about a\n(b ; about b\n  2\n  ; inner\n)\n; footer\n"
+	c, err := ParseCST([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := c.Nodes[0], c.Nodes[1]
+	if len(a.Leading) != 2 || a.Leading[0].Text != "; header" || a.Leading[1].Text != "\n\n" {
+		t.Errorf("unexpected leading trivia: %+v", a.Leading)
+	}
+	if len(a.Trailing) != 2 || a.Trailing[1].Text != "; about a" || !a.Trailing[1].Comment {
+		t.Errorf("unexpected trailing trivia: %+v", a.Trailing)
+	}
+	if tr := b.List[0].Trailing; len(tr) != 2 || tr[1].Text != "; about b" {
+		t.Errorf("unexpected trailing trivia: %+v", tr)
+	}
+	if len(b.Inner) != 3 || b.Inner[1].Text != "; inner" {
+		t.Errorf("unexpected inner trivia: %+v", b.Inner)
+	}
+	if len(c.Trailing) != 3 || c.Trailing[1].Text != "; footer" {
+		t.Errorf("unexpected trailing trivia: %+v", c.Trailing)
+	}
+}
+
+func TestCSTSetValue(t *testing.T) {
+	input := "; config\n(name nsf) ; user name\n(path \"/tmp\")\n(motd `\n    | hi\n`)\n"
+	c, err := ParseCST([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Nodes[0].List[1].SetValue("John Smith")
+	c.Nodes[1].List[1].SetValue("/var")
+	c.Nodes[2].List[1].SetValue("hello\nworld")
+	expected := "; config\n(name \"John Smith\") ; user name\n(path \"/var\")\n(motd `\n    | hello\n    | world\n`)\n"
+	if out := string(c.Bytes()); out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+	if k := c.Nodes[0].List[1].Kind; k != String {
+		t.Errorf("expected the kind to become a string, got: %s", k)
+	}
+}
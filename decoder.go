@@ -0,0 +1,347 @@
+package sx
+
+import (
+	"io"
+	"reflect"
+)
+
+const (
+	minReadSize   = 4096
+	maxEmptyReads = 100
+)
+
+// Decoder reads top-level nodes from an input stream one by one. It keeps
+// in memory only the part of the input which is not parsed yet, hence memory
+// use is bounded by the size of the biggest top-level node.
+type Decoder struct {
+	r   io.Reader
+	buf []byte
+	pos Pos   // position of buf[0] in the stream
+	err error // sticky error, either a read error or a syntax error
+	eof bool  // reader returned io.EOF
+
+	limits DecoderLimits
+
+	// Tracks the input read since the last returned node, it's valid if
+	// 'tracking' is set.
+	tracker  boundaryTracker
+	tracking bool
+
+	decodeState
+}
+
+// DecoderLimits are limits for untrusted input, they apply to every top-level
+// node separately. Zero means the default: unlimited nodes and sizes, a depth
+// of 10000 lists. Going over a limit stops the Decoder with a *LimitError.
+type DecoderLimits struct {
+	MaxDepth      int // maximum nesting depth of lists
+	MaxNodes      int // maximum number of nodes, lists included
+	MaxValueBytes int // maximum size of a value in bytes, after unescaping
+
+	// Maximum size of a top-level node in the input, spaces and comments
+	// inside of it and before it on the same line included. It bounds the
+	// memory used by the Decoder, which is otherwise unlimited for a node
+	// that never ends.
+	MaxNodeBytes int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, pos: Pos{Offset: 0, Line: 1, Column: 1}}
+}
+
+// Lexical state of the boundary tracker.
+type boundaryState int
+
+const (
+	bsNormal        boundaryState = iota
+	bsScalar                      // inside a bare scalar
+	bsComment                     // inside a comment
+	bsString                      // inside a string literal
+	bsEscape                      // after '\' in a string literal
+	bsBacktick                    // after opening '`'
+	bsBacktickCR                  // after opening "`\r"
+	bsRawString                   // inside a raw string literal
+	bsMultiLine                   // between lines of a multi-line string literal
+	bsMultiLineText               // inside a line of a multi-line string literal
+)
+
+// boundaryTracker follows the lexical structure of the input byte by byte,
+// looking for places where a top-level node may end. It lets the Decoder
+// reparse an incomplete node only when there is a reason to, instead of after
+// every read. Syntax errors count as such places as well.
+type boundaryTracker struct {
+	state boundaryState
+	depth int
+}
+
+// Feeds the data to the tracker, returns true if a top-level node may end
+// somewhere in it.
+func (t *boundaryTracker) feed(data []byte) bool {
+	found := false
+	for _, b := range data {
+		switch t.state {
+		case bsNormal, bsScalar:
+			if t.state == bsScalar {
+				if isScalar(int(b)) {
+					continue
+				}
+				t.state = bsNormal
+				found = found || t.depth == 0
+			}
+			switch b {
+			case '(':
+				t.depth++
+			case ')':
+				t.depth--
+				if t.depth <= 0 {
+					// the end of a node or an unmatched ')'
+					t.depth = 0
+					found = true
+				}
+			case '"':
+				t.state = bsString
+			case '`':
+				t.state = bsBacktick
+			case ';':
+				t.state = bsComment
+			default:
+				if isScalar(int(b)) {
+					t.state = bsScalar
+				}
+			}
+		case bsComment:
+			if b == '\n' {
+				t.state = bsNormal
+			}
+		case bsString:
+			switch b {
+			case '\\':
+				t.state = bsEscape
+			case '"':
+				t.state = bsNormal
+				found = found || t.depth == 0
+			case '\n':
+				t.state = bsNormal
+				found = true
+			}
+		case bsEscape:
+			t.state = bsString
+			if b == '\n' {
+				t.state = bsNormal
+				found = true
+			}
+		case bsBacktick, bsBacktickCR, bsRawString:
+			switch {
+			case t.state == bsBacktick && b == '\r':
+				t.state = bsBacktickCR
+			case t.state != bsRawString && b == '\n':
+				t.state = bsMultiLine
+			case b == '`':
+				t.state = bsNormal
+				found = found || t.depth == 0
+			case b == '\n':
+				t.state = bsNormal
+				found = true
+			default:
+				t.state = bsRawString
+			}
+		case bsMultiLine:
+			switch {
+			case b == '|':
+				t.state = bsMultiLineText
+			case b == '`':
+				t.state = bsNormal
+				found = found || t.depth == 0
+			case !isSpace(int(b)):
+				t.state = bsNormal
+				found = true
+			}
+		case bsMultiLineText:
+			if b == '\n' {
+				t.state = bsMultiLine
+			}
+		}
+	}
+	return found
+}
+
+// Reads more data into the buffer. It returns as soon as the data may
+// complete a top-level node, otherwise it keeps reading until the buffer grows
+// by at least as many bytes as it holds already (or the stream ends), so that
+// reparsing of a big incomplete node stays linear in total. A single Read may
+// return much less than asked for, hence it's called in a loop.
+func (d *Decoder) fill() {
+	if !d.tracking {
+		// the tracker starts from the beginning of the buffer, which
+		// is at the top level
+		d.tracker = boundaryTracker{}
+		d.tracker.feed(d.buf)
+		d.tracking = true
+	}
+
+	n := len(d.buf)
+	if n < minReadSize {
+		n = minReadSize
+	}
+	if cap(d.buf)-len(d.buf) < n {
+		buf := make([]byte, len(d.buf), len(d.buf)+n)
+		copy(buf, d.buf)
+		d.buf = buf
+	}
+	want := len(d.buf) + n
+	for empty := 0; len(d.buf) < want; {
+		read, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		found := d.tracker.feed(d.buf[len(d.buf) : len(d.buf)+read])
+		d.buf = d.buf[:len(d.buf)+read]
+		if err == io.EOF {
+			d.eof = true
+			return
+		} else if err != nil {
+			d.err = err
+			return
+		}
+		if found {
+			return
+		}
+		if read > 0 {
+			empty = 0
+		} else if empty++; empty == maxEmptyReads {
+			d.err = io.ErrNoProgress
+			return
+		}
+	}
+}
+
+func (d *Decoder) newParser() *parser {
+	p := newParserAt(d.buf, d.pos)
+	p.setLimits(d.limits.MaxDepth, d.limits.MaxNodes, d.limits.MaxValueBytes)
+	return p
+}
+
+// Returns true if a node which ends at the end of the buffer may continue in
+// the next chunk, that's only possible for bare scalars.
+func (d *Decoder) mayContinue(end int) bool {
+	return !d.eof && end == len(d.buf) && isScalar(int(d.buf[end-1]))
+}
+
+// Drops spaces and comments up to the last new line in the buffer, they
+// cannot be a part of any node.
+func (d *Decoder) dropTrivia() {
+	for i := len(d.buf) - 1; i >= 0; i-- {
+		if d.buf[i] == '\n' {
+			p := newParserAt(d.buf, d.pos)
+			p.advanceN(i + 1)
+			d.buf = d.buf[i+1:]
+			d.pos = p.pos()
+			return
+		}
+	}
+}
+
+// Next returns the next top-level node. It returns io.EOF when there are no
+// more nodes in the stream.
+func (d *Decoder) Next() (Node, error) {
+	for d.err == nil {
+		p := d.newParser()
+		node, ok := p.parseSingleNode()
+		_, limit := p.err.(*LimitError)
+		switch {
+		case limit:
+			// more data cannot bring the node back under a limit
+			d.err = p.err
+			return Node{}, d.err
+		case p.unexpectedEOF && !d.eof:
+			// incomplete node, wait for more data
+		case p.err != nil:
+			d.err = p.err
+			return Node{}, d.err
+		case !ok:
+			if d.eof {
+				d.err = io.EOF
+				return Node{}, d.err
+			}
+			d.dropTrivia()
+		case !d.mayContinue(p.ptr):
+			d.buf = d.buf[p.ptr:]
+			d.pos = p.pos()
+			d.tracking = false
+			return node, nil
+		}
+		if d.limits.MaxNodeBytes > 0 && len(d.buf) > d.limits.MaxNodeBytes {
+			d.err = &LimitError{Limit: "MaxNodeBytes", Max: d.limits.MaxNodeBytes, Pos: d.pos}
+			return Node{}, d.err
+		}
+		d.fill()
+	}
+	return Node{}, d.err
+}
+
+// Decode reads the next top-level node and stores it in the value pointed to
+// by 'out', following the same rules as Unmarshal does.
+func (d *Decoder) Decode(out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		// This is a library user mistake, not a usual error
+		panic("sx.Decoder.Decode expects a non-nil pointer as 'out' argument")
+	}
+
+	node, err := d.Next()
+	if err != nil {
+		return err
+	}
+	return d.unmarshalValue([]Node{node}, v.Elem())
+}
+
+// DecodeAll reads all of the remaining top-level nodes and stores them in the
+// value pointed to by 'out' as a whole, the same way Unmarshal does it for
+// the entire input.
+func (d *Decoder) DecodeAll(out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		// This is a library user mistake, not a usual error
+		panic("sx.Decoder.DecodeAll expects a non-nil pointer as 'out' argument")
+	}
+
+	var tree []Node
+	for {
+		node, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		tree = append(tree, node)
+	}
+	return d.unmarshalValue(tree, v.Elem())
+}
+
+// SetLimits sets limits for untrusted input, see DecoderLimits.
+func (d *Decoder) SetLimits(limits DecoderLimits) {
+	d.limits = limits
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when a struct
+// field list has a name which doesn't match any field of the struct.
+func (d *Decoder) DisallowUnknownFields() {
+	d.disallowUnknownFields = true
+}
+
+// DisallowDuplicateFields causes the Decoder to return an error when the same
+// struct field or map key is specified more than once.
+func (d *Decoder) DisallowDuplicateFields() {
+	d.disallowDuplicateFields = true
+}
+
+// DisallowExtraItems causes the Decoder to return an error when there are
+// more items than an array can hold, instead of dropping them.
+func (d *Decoder) DisallowExtraItems() {
+	d.disallowExtraItems = true
+}
+
+// UseMaps causes the Decoder to store lists of (key value...) lists as
+// map[string]interface{} when the target is an interface{} value, instead of
+// []interface{}.
+func (d *Decoder) UseMaps() {
+	d.useMaps = true
+}
@@ -0,0 +1,309 @@
+package sx
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func decodeAll(r io.Reader) ([]Node, error) {
+	var out []Node
+	d := NewDecoder(r)
+	for {
+		node, err := d.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, node)
+	}
+}
+
+// Excerpts may differ, because decoder sees only a part of the input.
+func sameError(a, b error) bool {
+	sa, ok1 := a.(*SyntaxError)
+	sb, ok2 := b.(*SyntaxError)
+	if ok1 && ok2 {
+		return sa.Msg == sb.Msg && sa.Pos == sb.Pos
+	}
+	return a == b
+}
+
+func TestDecoderNext(t *testing.T) {
+	inputs := []string{}
+	for _, c := range cases {
+		inputs = append(inputs, c.input)
+	}
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs = append(inputs, string(data), "abc ; comment", "`abc`\r\n`\r\n| x\r\n`", strings.Repeat("(a b c) ", 10000))
+
+	readers := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"plain", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"data err", iotest.DataErrReader},
+		{"half", iotest.HalfReader},
+	}
+	for i, input := range inputs {
+		expected, expectedErr := Parse([]byte(input))
+		for _, r := range readers {
+			result, err := decodeAll(r.wrap(strings.NewReader(input)))
+			if !sameError(err, expectedErr) {
+				t.Errorf("case %d (%s reader), got error: %v, expected: %v", i, r.name, err, expectedErr)
+				continue
+			}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("case %d (%s reader)\ngot:\n%s\nexpected:\n%s", i, r.name, prettyPrint(result), prettyPrint(expected))
+			}
+		}
+	}
+}
+
+func TestDecoderBoundedBuffer(t *testing.T) {
+	record := []byte("((name nsf) (email no.smile.face@gmail.com))\n; comment\n")
+	input := bytes.Repeat(record, 10000)
+	d := NewDecoder(bytes.NewReader(input))
+	for {
+		_, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cap(d.buf) > 4*minReadSize {
+			t.Fatalf("decoder buffer grew too big: %d bytes", cap(d.buf))
+		}
+	}
+}
+
+// Returns at most 'size' bytes per Read, like a pipe or a socket often does.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (r chunkReader) Read(p []byte) (int, error) {
+	if len(p) > r.size {
+		p = p[:r.size]
+	}
+	return r.r.Read(p)
+}
+
+func TestDecoderShortReads(t *testing.T) {
+	// Reparsing after every short read makes these quadratic, which takes
+	// tens of seconds instead of milliseconds.
+	inputs := []string{
+		"(" + strings.Repeat("item ", 1<<18) + ")",
+		"; " + strings.Repeat("comment ", 1<<17),
+		"(" + strings.Repeat("(item \"x\") ", 1<<16) + ")",
+	}
+	for i, input := range inputs {
+		expected, err := Parse([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := decodeAll(chunkReader{strings.NewReader(input), 4096})
+		if err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("case %d, got %d nodes, expected %d", i, len(result), len(expected))
+		}
+	}
+}
+
+// Nodes must be returned as soon as they are complete, even if the writer
+// keeps the stream open. Every chunk completes the node of its case, the node
+// may start in previous chunks.
+var decoderPipeCases = []struct {
+	chunks []string
+	nodes  []string
+}{
+	{[]string{"(a 1)\n"}, []string{"(a 1)"}},
+	{[]string{"(a", " (b 2)", " 3)"}, []string{"(a (b 2) 3)"}},
+	{[]string{"(a \")\"", ")"}, []string{"(a \")\")"}},
+	{[]string{"hello", " "}, []string{"hello"}},
+	{[]string{"\"a\\\"b", "\""}, []string{"\"a\\\"b\""}},
+	{[]string{"`(raw", "`"}, []string{"`(raw`"}},
+	{[]string{"`\n| x`\n", "| y\n", "`"}, []string{"`\n| x`\n| y\n`"}},
+	{[]string{"; comment (\n", "(a) (b)"}, []string{"(a)", "(b)"}},
+}
+
+func TestDecoderPipe(t *testing.T) {
+	for i, c := range decoderPipeCases {
+		r, w := io.Pipe()
+		d := NewDecoder(r)
+		go func() {
+			for _, chunk := range c.chunks {
+				w.Write([]byte(chunk))
+			}
+		}()
+		for _, expected := range c.nodes {
+			done := make(chan error, 1)
+			var node Node
+			go func() {
+				var err error
+				node, err = d.Next()
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				e, _ := Parse([]byte(expected))
+				if err != nil {
+					t.Errorf("case %d, unexpected error: %s", i, err)
+				} else if !equalTrees(stripSourceInfo([]Node{node}), stripSourceInfo(e)) {
+					t.Errorf("case %d, got %s, expected %s", i, prettyPrint([]Node{node}), expected)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("case %d, decoder is blocked, expected %s", i, expected)
+			}
+		}
+		w.Close()
+	}
+}
+
+var decoderLimitCases = []struct {
+	input  string
+	limits DecoderLimits
+	err    *LimitError
+}{
+	{"(a b)\n(c d)", DecoderLimits{MaxNodes: 3}, nil},
+	{"(a b)\n(c d e)", DecoderLimits{MaxNodes: 3}, &LimitError{"MaxNodes", 3, Pos{11, 2, 6}}},
+	{"(a (b (c)))", DecoderLimits{MaxDepth: 2}, &LimitError{"MaxDepth", 2, Pos{6, 1, 7}}},
+	{"(a \"bcd\")", DecoderLimits{MaxValueBytes: 2}, &LimitError{"MaxValueBytes", 2, Pos{3, 1, 4}}},
+	{"(a b)\n(c d)", DecoderLimits{MaxNodeBytes: 5}, nil},
+	{"(a b)\n(" + strings.Repeat(" ", 1<<20), DecoderLimits{MaxNodeBytes: 1000}, &LimitError{"MaxNodeBytes", 1000, Pos{5, 1, 6}}},
+	{"(a b)\n; " + strings.Repeat("comment ", 1<<17), DecoderLimits{MaxNodeBytes: 1000}, &LimitError{"MaxNodeBytes", 1000, Pos{6, 2, 1}}},
+}
+
+func TestDecoderLimits(t *testing.T) {
+	for i, c := range decoderLimitCases {
+		d := NewDecoder(strings.NewReader(c.input))
+		d.SetLimits(c.limits)
+		var err error
+		for err == nil {
+			_, err = d.Next()
+		}
+		if c.err == nil {
+			if err != io.EOF {
+				t.Errorf("case %d, unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(err, c.err) {
+			t.Errorf("case %d, got error: %v, expected: %v", i, err, c.err)
+		}
+		if cap(d.buf) > 2*minReadSize {
+			t.Errorf("case %d, decoder buffer grew too big: %d bytes", i, cap(d.buf))
+		}
+	}
+}
+
+func TestDecoderDecode(t *testing.T) {
+	input := "((name nsf) (email no.smile.face@gmail.com))\n((name foo) (email bar))\n(name"
+	expected := []SValidSimple{
+		{"nsf", "no.smile.face@gmail.com"},
+		{"foo", "bar"},
+	}
+	d := NewDecoder(strings.NewReader(input))
+	for i, e := range expected {
+		var v SValidSimple
+		if err := d.Decode(&v); err != nil {
+			t.Fatalf("record %d, unexpected error: %s", i, err)
+		}
+		if v != e {
+			t.Errorf("record %d, got %v, expected %v", i, v, e)
+		}
+	}
+	var v SValidSimple
+	err := d.Decode(&v)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Pos.Line != 3 {
+		t.Errorf("expected a syntax error on line 3, got: %v", err)
+	}
+	if err2 := d.Decode(&v); err2 != err {
+		t.Errorf("expected the same error on subsequent calls, got: %v", err2)
+	}
+}
+
+var strictDecodeCases = []struct {
+	input  string
+	schema interface{}
+	setup  func(d *Decoder)
+	msg    string
+}{
+	{"(intances 3)", &MarathonConfig{}, (*Decoder).DisallowUnknownFields,
+		`1:2: cannot unmarshal "intances" into value of type sx.MarathonConfig: unknown field`},
+	{"(container (docker (image x) (netwrk BRIDGE)))", &MarathonConfig{}, (*Decoder).DisallowUnknownFields,
+		`1:31: cannot unmarshal "netwrk" into container.docker of type sx.Docker: unknown field`},
+	{"(instances 3)\n(cpus 1)\n(instances 4)", &MarathonConfig{}, (*Decoder).DisallowDuplicateFields,
+		`3:2: cannot unmarshal "instances" into value of type sx.MarathonConfig: duplicate field`},
+	{"(Map (edit true) (view false) (edit false))", &S8{}, (*Decoder).DisallowDuplicateFields,
+		`1:32: cannot unmarshal "edit" into Map of type map[string]bool: duplicate map key`},
+	{"(Ints 1 2 3 4 5)", &S7{}, (*Decoder).DisallowExtraItems,
+		`1:15: cannot unmarshal "5" into Ints of type [4]int: too many items, expected at most 4`},
+}
+
+func TestDecoderStrict(t *testing.T) {
+	for i, c := range strictDecodeCases {
+		// the default is to be lenient
+		if err := NewDecoder(strings.NewReader(c.input)).DecodeAll(c.schema); err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+		}
+
+		d := NewDecoder(strings.NewReader(c.input))
+		c.setup(d)
+		err := d.DecodeAll(c.schema)
+		if _, ok := err.(*UnmarshalError); !ok {
+			t.Errorf("case %d, expected *UnmarshalError, got: %v", i, err)
+			continue
+		}
+		if err.Error() != c.msg {
+			t.Errorf("case %d, got message:\n%s\nexpected:\n%s", i, err, c.msg)
+		}
+	}
+}
+
+func TestDecoderUseMaps(t *testing.T) {
+	input := "(id app)\n(labels (env prod) (tier (web))) (args -v (x 1))\n(ports ((port 80)) ((port 443) (name https)))"
+	expected := map[string]interface{}{
+		"id":     "app",
+		"labels": map[string]interface{}{"env": "prod", "tier": []interface{}{"web"}},
+		"args":   []interface{}{"-v", []interface{}{"x", "1"}},
+		"ports": []interface{}{
+			map[string]interface{}{"port": "80"},
+			map[string]interface{}{"port": "443", "name": "https"},
+		},
+	}
+	d := NewDecoder(strings.NewReader(input))
+	d.UseMaps()
+	var v interface{}
+	if err := d.DecodeAll(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("got:\n%#v\nexpected:\n%#v", v, expected)
+	}
+
+	d = NewDecoder(strings.NewReader("(labels (env prod) (env dev))"))
+	d.UseMaps()
+	d.DisallowDuplicateFields()
+	err := d.DecodeAll(&v)
+	msg := `1:21: cannot unmarshal "env" into labels of type map[string]interface {}: duplicate map key`
+	if err == nil || err.Error() != msg {
+		t.Errorf("got error:\n%v\nexpected:\n%s", err, msg)
+	}
+}
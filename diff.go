@@ -0,0 +1,228 @@
+package sx
+
+import (
+	"strconv"
+)
+
+//----------------------------------------------------------------------------
+// diff
+//----------------------------------------------------------------------------
+
+// ChangeKind is a kind of a difference between two documents.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	if int(k) < len(changeKindNames) {
+		return changeKindNames[k]
+	}
+	return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+var changeKindNames = []string{"added", "removed", "changed"}
+
+// Change is a difference between two documents. Path uses the Query syntax,
+// the same as paths reported by Walk. Old is empty for added values, New is
+// empty for removed ones.
+type Change struct {
+	Kind ChangeKind
+	Path string
+	Old  []Node
+	New  []Node
+}
+
+// Appends nodes on a single line, separated by spaces.
+func appendInline(buf []byte, nodes []Node) []byte {
+	for i, n := range nodes {
+		if i != 0 {
+			buf = append(buf, ' ')
+		}
+		switch {
+		case !n.IsScalar():
+			buf = append(buf, '(')
+			buf = appendInline(buf, n.List)
+			buf = append(buf, ')')
+		case canBeScalar(n.Value):
+			buf = append(buf, n.Value...)
+		default:
+			buf = appendStringLiteral(buf, n.Value)
+		}
+	}
+	return buf
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+// String returns a human-readable form of the change, for example:
+//
+//	~ container.docker.image: group/image -> group/image2
+//	+ labels.team: core
+//	- ports[1]: 9000
+func (c Change) String() string {
+	var buf []byte
+	switch c.Kind {
+	case Added:
+		buf = append(buf, "+ "...)
+	case Removed:
+		buf = append(buf, "- "...)
+	default:
+		buf = append(buf, "~ "...)
+	}
+	buf = append(buf, displayPath(c.Path)...)
+	buf = append(buf, ':')
+	if c.Kind != Added {
+		buf = append(buf, ' ')
+		buf = appendInline(buf, c.Old)
+	}
+	if c.Kind == Changed {
+		buf = append(buf, " ->"...)
+	}
+	if c.Kind != Removed {
+		buf = append(buf, ' ')
+		buf = appendInline(buf, c.New)
+	}
+	return string(buf)
+}
+
+// Node returns a machine-readable form of the change:
+// (changed PATH (old VALUE...) (new VALUE...)), added values have no 'old'
+// list and removed values have no 'new' list.
+func (c Change) Node() Node {
+	list := []Node{{Value: c.Kind.String()}, {Value: displayPath(c.Path)}}
+	if c.Kind != Added {
+		list = append(list, Node{List: append([]Node{{Value: "old"}}, c.Old...), Kind: List})
+	}
+	if c.Kind != Removed {
+		list = append(list, Node{List: append([]Node{{Value: "new"}}, c.New...), Kind: List})
+	}
+	return Node{List: list, Kind: List}
+}
+
+// Compares values and structure, ignoring source forms and positions.
+func nodesEqual(a, b []Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].IsScalar() != b[i].IsScalar() {
+			return false
+		}
+		if a[i].IsScalar() {
+			if a[i].Value != b[i].Value {
+				return false
+			}
+		} else if !nodesEqual(a[i].List, b[i].List) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if every node is a (key value...) list and keys are unique.
+func isKeyedTree(tree []Node) bool {
+	keys := make(map[string]bool, len(tree))
+	for i := range tree {
+		if !tree[i].isKeyed() || keys[tree[i].List[0].Value] {
+			return false
+		}
+		keys[tree[i].List[0].Value] = true
+	}
+	return true
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(kind ChangeKind, path string, a, b []Node) {
+	d.changes = append(d.changes, Change{Kind: kind, Path: path, Old: a, New: b})
+}
+
+// Lists with unique keys on both sides are compared by keys, everything else
+// is compared element by element.
+func (d *differ) diffTrees(path string, a, b []Node) {
+	if nodesEqual(a, b) {
+		return
+	}
+	ma, mb := indirectMap(a), indirectMap(b)
+	if isKeyedTree(ma) && isKeyedTree(mb) && (len(ma) != 0 || len(mb) != 0) {
+		d.diffKeyed(path, ma, mb)
+		return
+	}
+	if len(a) == 1 && len(b) == 1 && (a[0].IsScalar() || b[0].IsScalar()) {
+		d.add(Changed, path, a, b)
+		return
+	}
+	d.diffElems(path, a, b)
+}
+
+func (d *differ) diffKeyed(path string, a, b []Node) {
+	inA := make(map[string]bool, len(a))
+	for i := range a {
+		inA[a[i].List[0].Value] = true
+	}
+	byKey := make(map[string]*Node, len(b))
+	for i := range b {
+		byKey[b[i].List[0].Value] = &b[i]
+	}
+	for i := range a {
+		key := a[i].List[0].Value
+		kpath := appendPathKey(path, key)
+		if n, ok := byKey[key]; ok {
+			d.diffTrees(kpath, a[i].List[1:], n.List[1:])
+		} else {
+			d.add(Removed, kpath, a[i].List[1:], nil)
+		}
+	}
+	for i := range b {
+		key := b[i].List[0].Value
+		if !inA[key] {
+			d.add(Added, appendPathKey(path, key), nil, b[i].List[1:])
+		}
+	}
+}
+
+// Elements are paired by their positions, extra elements are added or
+// removed. Element paths follow the Walk conventions.
+func (d *differ) diffElems(path string, a, b []Node) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		ipath := path + "[" + strconv.Itoa(i) + "]"
+		switch {
+		case i >= len(b):
+			d.add(Removed, ipath, a[i:i+1], nil)
+		case i >= len(a):
+			d.add(Added, ipath, nil, b[i:i+1])
+		case a[i].isKeyed() && b[i].isKeyed() && a[i].List[0].Value == b[i].List[0].Value:
+			d.diffTrees(appendPathKey(path, a[i].List[0].Value), a[i].List[1:], b[i].List[1:])
+		case !a[i].IsScalar() && !b[i].IsScalar() && !a[i].isKeyed() && !b[i].isKeyed():
+			d.diffTrees(ipath, a[i].List, b[i].List)
+		case !nodesEqual(a[i:i+1], b[i:i+1]):
+			d.add(Changed, ipath, a[i:i+1], b[i:i+1])
+		}
+	}
+}
+
+// Diff returns differences between two documents, from 'a' to 'b'. Only
+// values and structure are compared, formatting, comments and literal forms
+// of values don't matter.
+//
+// Lists following the (key value...) convention are compared by keys when
+// keys are unique on both sides, thus reordering such lists is not a change.
+// Other lists are compared element by element. Changes are reported at the
+// deepest path possible, e.g. "container.docker.image" rather than
+// "container".
+func Diff(a, b []Node) []Change {
+	var d differ
+	d.diffTrees("", a, b)
+	return d.changes
+}
@@ -0,0 +1,103 @@
+package sx
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var diffCases = []struct {
+	a        string
+	b        string
+	expected string // changes in the plain form, one per line
+}{
+	{"(a 1) (b 2)", "(b 2)\n; comment\n(a \"1\")", ""},
+	{"(a `x y`)", "(a \"x y\")", ""},
+	{"(a 1) (b 2)", "(a 1) (b 3)", "~ b: 2 -> 3"},
+	{"(a 1) (b 2)", "(a 1)", "- b: 2"},
+	{"(a 1)", "(a 1) (b 2 3)", "+ b: 2 3"},
+	{"(ports 8080 9000)", "(ports 8080 9001 9002)", "~ ports[1]: 9000 -> 9001\n+ ports[2]: 9002"},
+	{"(ports 8080 9000)", "(ports 8080)", "- ports[1]: 9000"},
+	{"(a 1)", "(a (b 2))", "~ a: 1 -> (b 2)"},
+	{"(a (b (c 1) (d 2)))", "(a (b (d 2) (c \"hello world\")))", "~ a.b.c: 1 -> \"hello world\""},
+	{"(a (b 1))", "(a ((b 2)))", "~ a.b: 1 -> 2"},
+	{"(a ())", "(a (b 1))", "+ a.b: 1"},
+	{"(a.b 1)", "(a.b 2)", "~ \"a.b\": 1 -> 2"},
+	{"(m ((x 1)) ((x 2)))", "(m ((x 1)) ((x 3) (y 4)))", "~ m[1].x: 2 -> 3\n+ m[1].y: 4"},
+	{"(a 1) (a 2)", "(a 1) (a 3)", "~ a: 2 -> 3"},
+	{"(a 1) (a 2)", "(a 1) (b 2)", "~ [1]: (a 2) -> (b 2)"},
+	{"1 2", "1 (2)", "~ [1]: 2 -> (2)"},
+	{"x", "y", "~ .: x -> y"},
+	{"", "(a 1)", "+ a: 1"},
+}
+
+func TestDiff(t *testing.T) {
+	for i, c := range diffCases {
+		a, err := Parse([]byte(c.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Parse([]byte(c.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, change := range Diff(a, b) {
+			lines = append(lines, change.String())
+		}
+		if out := strings.Join(lines, "\n"); out != c.expected {
+			t.Errorf("case %d, got:\n%s\nexpected:\n%s", i, out, c.expected)
+		}
+	}
+}
+
+func TestDiffMarathon(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := Format(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Parse(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(a, b); len(changes) != 0 {
+		t.Errorf("expected no changes after formatting, got: %v", changes)
+	}
+
+	lookup := func(nodes []Node, keys ...string) []Node {
+		v, ok := Lookup(nodes, keys...)
+		if !ok {
+			t.Fatalf("%v not found", keys)
+		}
+		return v
+	}
+	lookup(b, "container", "docker", "image")[0].Value = "group/image2"
+	lookup(b, "container", "docker", "portMappings")[1].List[1].List[1].Value = "8080"
+
+	var nodes []Node
+	for _, c := range Diff(a, b) {
+		nodes = append(nodes, c.Node())
+	}
+	expected := `(changed
+    container.docker.image
+    (old group/image)
+    (new group/image2)
+)
+(changed
+    container.docker.portMappings[1].hostPort
+    (old 0)
+    (new 8080)
+)
+`
+	if out := string(Print(nodes)); out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+}
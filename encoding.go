@@ -0,0 +1,92 @@
+package sx
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//----------------------------------------------------------------------------
+// input encoding
+//----------------------------------------------------------------------------
+
+// UTF8Policy tells the parser what to do with invalid UTF-8 in the input.
+type UTF8Policy int
+
+const (
+	UTF8Ignore  UTF8Policy = iota // take bytes as is, sx is defined in terms of bytes
+	UTF8Reject                    // every invalid sequence is a syntax error
+	UTF8Replace                   // every invalid sequence is replaced with U+FFFD
+)
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// Converts UTF-16 input to UTF-8 if it starts with a byte order mark. The mark
+// itself is dropped. Returns the input as is otherwise.
+func transcodeUTF16(data []byte) []byte {
+	var order func(b []byte) uint16
+	switch {
+	case bytes.HasPrefix(data, utf16LEBOM):
+		order = func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+	case bytes.HasPrefix(data, utf16BEBOM):
+		order = func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+	default:
+		return data
+	}
+
+	data = data[2:]
+	units := make([]uint16, 0, len(data)/2)
+	for len(data) >= 2 {
+		units = append(units, order(data))
+		data = data[2:]
+	}
+	out := make([]byte, 0, len(units)+len(units)/2)
+	for _, r := range utf16.Decode(units) {
+		out = utf8.AppendRune(out, r)
+	}
+	if len(data) != 0 {
+		// odd trailing byte
+		out = utf8.AppendRune(out, utf8.RuneError)
+	}
+	return out
+}
+
+// Reports every invalid UTF-8 sequence as an error.
+func (p *parser) checkUTF8() {
+	for p.ptr < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.ptr:])
+		if r == utf8.RuneError && size == 1 {
+			p.error("invalid UTF-8 encoding")
+		}
+		p.advanceN(size)
+	}
+}
+
+// Applies encoding related options to the input. Returns the data to parse
+// and a parser with encoding errors, if any.
+func prepareInput(data []byte, opts ParseOptions) ([]byte, *parser) {
+	if opts.DetectUTF16 {
+		data = transcodeUTF16(data)
+	}
+	if opts.StripBOM {
+		data = bytes.TrimPrefix(data, utf8BOM)
+	}
+	switch opts.InvalidUTF8 {
+	case UTF8Reject:
+		p := newParser(data)
+		p.recover = opts.AllErrors
+		p.checkUTF8()
+		if p.err != nil {
+			return data, p
+		}
+	case UTF8Replace:
+		if !utf8.Valid(data) {
+			data = bytes.ToValidUTF8(data, []byte(string(utf8.RuneError)))
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,65 @@
+package sx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func utf16LE(s string) string {
+	out := []byte{0xFF, 0xFE}
+	for _, r := range s {
+		out = append(out, byte(r), byte(r>>8))
+	}
+	return string(out)
+}
+
+func utf16BE(s string) string {
+	out := []byte{0xFE, 0xFF}
+	for _, r := range s {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return string(out)
+}
+
+var encodingCases = []struct {
+	input    string
+	opts     ParseOptions
+	expected []Node
+	errors   []Pos
+}{
+	{"\xEF\xBB\xBFa", ParseOptions{}, expect("\xEF\xBB\xBFa"), nil},
+	{"\xEF\xBB\xBFa", ParseOptions{StripBOM: true}, expect("a"), nil},
+	{"(a \xFF)", ParseOptions{}, []Node{{List: expect("a", "\xFF")}}, nil},
+	{"(a \xFF)", ParseOptions{InvalidUTF8: UTF8Replace}, []Node{{List: expect("a", "\uFFFD")}}, nil},
+	{"(a \"é\")", ParseOptions{InvalidUTF8: UTF8Reject}, expectJson(`[["a", "é"]]`), nil},
+	{"(a\n \xFF)", ParseOptions{InvalidUTF8: UTF8Reject}, nil, []Pos{{4, 2, 2}}},
+	{"(a\n \xFF \"\\q\" ; \xC3\n)", ParseOptions{InvalidUTF8: UTF8Reject, AllErrors: true},
+		[]Node{{List: expect("a", "\xFF")}}, []Pos{{4, 2, 2}, {7, 2, 5}, {13, 2, 11}}},
+	{utf16LE("(name \"Jürgen\")"), ParseOptions{DetectUTF16: true}, expectJson(`[["name", "Jürgen"]]`), nil},
+	{utf16BE("(name \"Jürgen\")"), ParseOptions{DetectUTF16: true}, expectJson(`[["name", "Jürgen"]]`), nil},
+	{utf16BE("a") + "\x00", ParseOptions{DetectUTF16: true}, expect("a\uFFFD"), nil},
+}
+
+func TestEncoding(t *testing.T) {
+	for i, c := range encodingCases {
+		result, err := ParseWithOptions([]byte(c.input), c.opts)
+		if !reflect.DeepEqual(stripSourceInfo(result), c.expected) {
+			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, prettyPrint(result), prettyPrint(c.expected))
+		}
+		var positions []Pos
+		switch err := err.(type) {
+		case nil:
+		case *SyntaxError:
+			positions = append(positions, err.Pos)
+		case ErrorList:
+			for _, e := range err {
+				positions = append(positions, e.Pos)
+			}
+		default:
+			t.Errorf("case %d, unexpected error: %s", i, err)
+		}
+		if !reflect.DeepEqual(positions, c.errors) {
+			t.Errorf("case %d, got errors: %v, expected positions: %v", i, err, c.errors)
+		}
+	}
+}
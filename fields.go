@@ -0,0 +1,276 @@
+package sx
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field is a struct field visible to sx, it may be promoted from an embedded
+// struct.
+type field struct {
+	name       string // sx name: a tag or a Go field name
+	goName     string
+	index      []int
+	typ        reflect.Type
+	tagged     bool
+	unexported bool
+
+	// tag options
+	required   bool
+	omitEmpty  bool
+	hasDefault bool
+	def        string // default value, sx source
+	defTree    []Node // parsed default value, shared by all decoded values
+	defErr     error  // syntax error in the default value
+}
+
+// Parses an sx tag: a name followed by comma-separated options. The default
+// value option must be the last one, because sx literals may contain commas,
+// e.g. `sx:"port,omitempty,default=8080"`.
+func parseTag(tag string, f *field) {
+	name := tag
+	if i := strings.IndexByte(tag, ','); i != -1 {
+		name, tag = tag[:i], tag[i+1:]
+	} else {
+		tag = ""
+	}
+	for tag != "" {
+		if strings.HasPrefix(tag, "default=") {
+			f.hasDefault = true
+			f.def = tag[len("default="):]
+			break
+		}
+		opt := tag
+		if i := strings.IndexByte(tag, ','); i != -1 {
+			opt, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+		switch opt {
+		case "required":
+			f.required = true
+		case "omitempty":
+			f.omitEmpty = true
+		}
+	}
+	f.name = name
+	f.tagged = name != ""
+}
+
+// Returns fields of a struct type, fields of embedded structs are promoted
+// following the encoding/json rules: a field at the shallowest depth wins
+// over deeper ones, a tagged field wins over untagged ones at the same depth,
+// otherwise conflicting fields are ignored. Unexported fields are returned as
+// well, marked so, because writing to them is an error.
+func typeFields(t reflect.Type) []field {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	current := []embedded{}
+	next := []embedded{{typ: t}}
+	visited := map[reflect.Type]bool{}
+
+	// Number of times a struct type is embedded at the current and the next
+	// depth level. Only the first instance is explored, fields of the others
+	// are represented by duplicates, which then conflict with each other.
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+
+	var fields []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i, n := 0, e.typ.NumField(); i < n; i++ {
+				f := e.typ.Field(i)
+				tag := f.Tag.Get("sx")
+				if tag == "-" {
+					continue
+				}
+				index := make([]int, len(e.index)+1)
+				copy(index, e.index)
+				index[len(e.index)] = i
+
+				var sf field
+				parseTag(tag, &sf)
+
+				ft := f.Type
+				if f.Anonymous {
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if sf.name == "" && ft.Kind() == reflect.Struct {
+						// promote fields of the embedded struct, even
+						// an unexported one, on the next depth level
+						nextCount[ft]++
+						if nextCount[ft] == 1 {
+							next = append(next, embedded{typ: ft, index: index})
+						}
+						continue
+					}
+					if f.PkgPath != "" {
+						// unexported non-struct embedded type
+						continue
+					}
+				}
+
+				if sf.name == "" {
+					sf.name = f.Name
+				}
+				sf.goName = f.Name
+				sf.index = index
+				sf.typ = f.Type
+				sf.unexported = f.PkgPath != "" && !f.Anonymous
+				fields = append(fields, sf)
+				if count[e.typ] > 1 {
+					// the type is embedded more than once at this
+					// depth, make sure the field is seen as ambiguous
+					fields = append(fields, sf)
+				}
+			}
+		}
+	}
+
+	// Sort by name, then by depth, then by presence of a tag, then by
+	// index sequence. This way the dominant field for each name comes first.
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		if a.tagged != b.tagged {
+			return a.tagged
+		}
+		return indexLess(a.index, b.index)
+	})
+
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		if f, ok := dominantField(fields[i:j]); ok {
+			out = append(out, f)
+		}
+		i = j
+	}
+
+	// restore the declaration order
+	sort.Slice(out, func(i, j int) bool {
+		return indexLess(out[i].index, out[j].index)
+	})
+	return out
+}
+
+func indexLess(a, b []int) bool {
+	for k, x := range a {
+		if k >= len(b) {
+			return false
+		}
+		if x != b[k] {
+			return x < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// Fields are sorted by depth and presence of a tag. If there are multiple
+// top fields, they conflict and none of them is used.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+// structPlan is everything needed to decode and encode a struct type, it's
+// computed once per type.
+type structPlan struct {
+	fields  []field
+	names   map[string]int // sx name -> index in fields
+	goNames map[string]int // Go name -> index in fields
+	missing bool           // some fields are required or have a default
+}
+
+var structPlans sync.Map // map[reflect.Type]*structPlan
+
+func cachedStructPlan(t reflect.Type) *structPlan {
+	if p, ok := structPlans.Load(t); ok {
+		return p.(*structPlan)
+	}
+	fields := typeFields(t)
+	p := &structPlan{
+		fields:  fields,
+		names:   make(map[string]int, len(fields)),
+		goNames: make(map[string]int, len(fields)),
+	}
+	for i, f := range fields {
+		p.names[f.name] = i
+		p.goNames[f.goName] = i
+		if f.required || f.hasDefault {
+			p.missing = true
+		}
+		if f.hasDefault {
+			fields[i].defTree, fields[i].defErr = Parse([]byte(f.def))
+		}
+	}
+	actual, _ := structPlans.LoadOrStore(t, p)
+	return actual.(*structPlan)
+}
+
+// Looks up a field by its sx name, falls back to the Go name of a field.
+// Returns an index of the field in 'fields'.
+func (p *structPlan) lookup(name string) (int, bool) {
+	if i, ok := p.names[name]; ok {
+		return i, true
+	}
+	i, ok := p.goNames[name]
+	return i, ok
+}
+
+// Returns a struct field by its index sequence, allocating nil embedded
+// struct pointers on the way.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, errors.New("cannot set embedded pointer to unexported struct: " + v.Type().Elem().String())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// Same as fieldByIndex, but doesn't allocate anything. Returns false if
+// there is a nil embedded pointer on the way.
+func fieldByIndexNoAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,165 @@
+package sx
+
+import (
+	"strings"
+)
+
+//----------------------------------------------------------------------------
+// canonical formatting
+//----------------------------------------------------------------------------
+
+func hasComments(trivia []Trivia) bool {
+	for _, t := range trivia {
+		if t.Comment {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if a CST list cannot be written on a single line: it contains
+// non-empty lists or comments.
+func isBrokenCSTList(n *CSTNode) bool {
+	if hasComments(n.Inner) {
+		return true
+	}
+	for _, elem := range n.List {
+		if elem.Kind == List && (len(elem.List) != 0 || hasComments(elem.Inner)) {
+			return true
+		}
+		if hasComments(elem.Leading) || hasComments(elem.Trailing) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) writeComment(text string) {
+	p.buf.WriteString(strings.TrimRight(text, " \t\r"))
+}
+
+// Writes comments of the trivia, each on its own line with the given
+// indentation. A run of empty lines becomes a single empty line, empty lines
+// are dropped at the beginning of a block. Returns true if there is an empty
+// line after the last comment, it's up to the caller to write it.
+func (p *printer) writeLineTrivia(trivia []Trivia, depth int, first bool) bool {
+	blank := false
+	for _, t := range trivia {
+		if !t.Comment {
+			if strings.Count(t.Text, "\n") >= 2 {
+				blank = true
+			}
+			continue
+		}
+		if blank && !first {
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteByte('\n')
+		p.writeIndent(depth)
+		p.writeComment(t.Text)
+		blank, first = false, false
+	}
+	return blank && !first
+}
+
+func (p *printer) writeTrailingTrivia(trivia []Trivia) {
+	for _, t := range trivia {
+		if t.Comment {
+			p.buf.WriteByte(' ')
+			p.writeComment(t.Text)
+		}
+	}
+}
+
+// Same layout rules as for writeNode apply, comments force a list to be
+// broken into lines.
+func (p *printer) writeCSTNode(n *CSTNode, depth int) {
+	if n.Kind != List {
+		v := Node{Value: n.Value}
+		if looksLikeJSONLiteral(n.Value) {
+			// Quotes tell ToJSON it's a string, not a number or a boolean.
+			v.Kind = n.Kind
+		}
+		p.writeValue(v, depth)
+		return
+	}
+
+	if !isBrokenCSTList(n) {
+		p.buf.WriteByte('(')
+		for i, elem := range n.List {
+			if i != 0 {
+				p.buf.WriteByte(' ')
+			}
+			p.writeCSTNode(elem, depth)
+		}
+		p.buf.WriteByte(')')
+		return
+	}
+
+	if len(n.List) == 2 && n.List[0].Kind != List && n.List[1].Kind == List &&
+		isBrokenCSTList(n.List[1]) && !hasComments(n.Inner) &&
+		!hasComments(n.List[0].Leading) && !hasComments(n.List[0].Trailing) &&
+		!hasComments(n.List[1].Leading) && !hasComments(n.List[1].Trailing) {
+		p.buf.WriteByte('(')
+		p.writeCSTNode(n.List[0], depth)
+		p.buf.WriteByte(' ')
+		p.writeCSTNode(n.List[1], depth)
+		p.buf.WriteByte(')')
+		return
+	}
+
+	p.buf.WriteByte('(')
+	elems := n.List
+	if len(elems) != 0 && elems[0].Kind != List && !hasComments(elems[0].Leading) {
+		p.writeCSTNode(elems[0], depth)
+		p.writeTrailingTrivia(elems[0].Trailing)
+		elems = elems[1:]
+	}
+	first := true
+	for _, elem := range elems {
+		if p.writeLineTrivia(elem.Leading, depth+1, first) {
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteByte('\n')
+		p.writeIndent(depth + 1)
+		p.writeCSTNode(elem, depth+1)
+		p.writeTrailingTrivia(elem.Trailing)
+		first = false
+	}
+	p.writeLineTrivia(n.Inner, depth+1, first)
+	p.buf.WriteByte('\n')
+	p.writeIndent(depth)
+	p.buf.WriteByte(')')
+}
+
+// Every top-level node starts with a new line, including the first one.
+func (p *printer) writeCST(c *CST) {
+	for i, n := range c.Nodes {
+		if p.writeLineTrivia(n.Leading, 0, i == 0) {
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteByte('\n')
+		p.writeCSTNode(n, 0)
+		p.writeTrailingTrivia(n.Trailing)
+	}
+	p.writeLineTrivia(c.Trailing, 0, len(c.Nodes) == 0)
+}
+
+// Format returns canonically formatted sx source. Nested lists are indented
+// consistently, multi-line string literals are re-indented, every value is
+// written using the simplest literal form available, except that quoted values
+// which look like JSON numbers, booleans or null stay quoted. Comments and
+// single empty lines between nodes are preserved.
+func Format(src []byte) ([]byte, error) {
+	c, err := ParseCST(src)
+	if err != nil {
+		return nil, err
+	}
+	var p printer
+	p.writeCST(c)
+	out := p.buf.Bytes()
+	if len(out) == 0 {
+		return out, nil
+	}
+	return append(out[1:], '\n'), nil
+}
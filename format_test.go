@@ -0,0 +1,82 @@
+package sx
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+var formatCases = []struct {
+	input    string
+	expected string
+}{
+	{"", ""},
+	{"\n\n", ""},
+	{"; comment  \r\n", "; comment\n"},
+	{"hello   world", "hello\nworld\n"},
+	{"(a   \"b\"   `c`)", "(a b c)\n"},
+	{"(port \"8080\" 8080 `true` \"1.5x\")", "(port \"8080\" 8080 `true` 1.5x)\n"},
+	{"(a \"b c\" `C:\\Program Files` \"\\x00\")", "(a \"b c\" `C:\\Program Files` \"\\x00\")\n"},
+	{"(a () (  ))", "(a () ())\n"},
+	{"(a (b c) d)", "(a\n    (b c)\n    d\n)\n"},
+	{"((a 1)(b 2))", "(\n    (a 1)\n    (b 2)\n)\n"},
+	{"(constraints ((a b c)))", "(constraints (\n    (a b c)\n))\n"},
+	{"(a\n\n\n  (b 1)\n\n\n\n  (c 2)\n\n)", "(a\n    (b 1)\n\n    (c 2)\n)\n"},
+	{"(a ; head\n  (b 1) ; one\n  ; before c\n\n  ; still before c\n  (c 2)\n  ; inner\n)",
+		"(a ; head\n    (b 1) ; one\n    ; before c\n\n    ; still before c\n    (c 2)\n    ; inner\n)\n"},
+	{"(a b ; c\n)", "(a\n    b ; c\n)\n"},
+	{"(a ; c\n b)", "(a ; c\n    b\n)\n"},
+	{"(msg `\n|hello\n      |  world\n   `)", "(msg `\n    | hello\n    |  world\n`)\n"},
+	{"(a `\n| x\n`)", "(a x)\n"},
+	{"(a \"\\x00\\nb\" \"x\\ty\\nz\")", "(a \"\\x00\\nb\" `\n    | x\ty\n    | z\n`)\n"},
+	{"(a (msg `\n| x\n| y\n`))", "(a\n    (msg `\n        | x\n        | y\n    `)\n)\n"},
+	{"(a (b (msg `\n| x\n| y\n`) (c)))", "(a (b\n    (msg `\n        | x\n        | y\n    `)\n    (c)\n))\n"},
+	{"; header\n\n\n(a 1) ; trailing\n\n\n; footer\n\n", "; header\n\n(a 1) ; trailing\n\n; footer\n"},
+	{"(a 1)\n; about b\n(b 2)", "(a 1)\n; about b\n(b 2)\n"},
+	{"(\n; only comment\n)", "(\n    ; only comment\n)\n"},
+}
+
+func TestFormat(t *testing.T) {
+	for i, c := range formatCases {
+		out, err := Format([]byte(c.input))
+		if err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if string(out) != c.expected {
+			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, out, c.expected)
+			continue
+		}
+		again, err := Format(out)
+		if err != nil || string(again) != string(out) {
+			t.Errorf("case %d, formatting is not idempotent:\n%s", i, again)
+		}
+	}
+
+	if _, err := Format([]byte("(a")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestFormatMarathon(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Format(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := Parse(data)
+	b, _ := Parse(out)
+	if !equalTrees(stripSourceInfo(a), stripSourceInfo(b)) {
+		t.Errorf("formatting changed the tree:\n%s", out)
+	}
+	again, _ := Format(out)
+	if string(again) != string(out) {
+		t.Errorf("formatting is not idempotent:\n%s", again)
+	}
+}
+
+func equalTrees(a, b []Node) bool {
+	return prettyPrint(a) == prettyPrint(b)
+}
@@ -0,0 +1,340 @@
+package sx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//----------------------------------------------------------------------------
+// json conversion
+//----------------------------------------------------------------------------
+
+// Returns true if a string is a number in JSON syntax.
+func isJSONNumber(s string) bool {
+	i := 0
+	digits := func() int {
+		n := 0
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+			n++
+		}
+		return n
+	}
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	if i < len(s) && s[i] == '0' {
+		i++
+	} else if digits() == 0 {
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		if digits() == 0 {
+			return false
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return i == len(s)
+}
+
+// Returns true if a string would be read back as a number, a boolean or null
+// if written as a bare scalar.
+func looksLikeJSONLiteral(s string) bool {
+	switch s {
+	case "true", "false", "null":
+		return true
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+type jsonReader struct {
+	dec *json.Decoder
+}
+
+// Reads a JSON value and returns its tree. Objects become sequences of
+// (key value...) lists and arrays become sequences of their elements, using
+// the same conventions as Marshal. The order of keys is preserved.
+func (r *jsonReader) readValue() ([]Node, error) {
+	tok, err := r.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return r.readValueStartingWith(tok)
+}
+
+func (r *jsonReader) readValueStartingWith(tok json.Token) ([]Node, error) {
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			return r.readArray()
+		}
+		return r.readObject()
+	case string:
+		kind := Scalar
+		if looksLikeJSONLiteral(t) {
+			kind = String
+		}
+		return []Node{{Value: t, Kind: kind}}, nil
+	case json.Number:
+		return []Node{{Value: t.String()}}, nil
+	case bool:
+		return []Node{{Value: strconv.FormatBool(t)}}, nil
+	case nil:
+		return []Node{{Value: "null"}}, nil
+	}
+	return nil, fmt.Errorf("unexpected json token: %v", tok)
+}
+
+// Expects opening '[' to be read already.
+func (r *jsonReader) readArray() ([]Node, error) {
+	out := []Node{}
+	for r.dec.More() {
+		tree, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, treeToNode(tree))
+	}
+	if _, err := r.dec.Token(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return emptyTree(), nil
+	}
+	if isTreeList(out) {
+		// see marshalValue
+		out = []Node{{List: out, Kind: List}}
+	}
+	return out, nil
+}
+
+// Expects opening '{' to be read already.
+func (r *jsonReader) readObject() ([]Node, error) {
+	out := []Node{}
+	for r.dec.More() {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		tree, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Node{List: append([]Node{{Value: key}}, tree...), Kind: List})
+	}
+	if _, err := r.dec.Token(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return emptyTree(), nil
+	}
+	return out, nil
+}
+
+// FromJSON converts a JSON document to sx. Objects become (key value...)
+// lists, arrays become lists, strings which look like numbers, booleans or
+// null are quoted to keep them apart from real ones. Elements of a top-level
+// array or object become top-level nodes.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	r := jsonReader{dec: dec}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := r.readValueStartingWith(tok)
+	if err != nil {
+		return nil, err
+	}
+	if isTreeList(tree) && (tok == json.Delim('[') || len(tree[0].List) == 0) {
+		// At the top level there is no need for an extra list around a
+		// single list element, empty containers become empty documents.
+		tree = tree[0].List
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid json: unexpected data after the top-level value")
+	}
+
+	var p printer
+	p.writeNodes(tree)
+	return p.buf.Bytes(), nil
+}
+
+type jsonWriter struct {
+	buf bytes.Buffer
+	enc *json.Encoder // writes to buf, used for strings
+}
+
+func treeError(tree []Node, msg string) error {
+	if len(tree) == 0 {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s: %s", tree[0].Start, msg)
+}
+
+// Returns true if the tree looks like an object: (key value...) lists with
+// unique scalar keys.
+func isObjectTree(tree []Node) bool {
+	if !isTreeMap(tree) {
+		return false
+	}
+	keys := make(map[string]bool, len(tree))
+	for _, node := range tree {
+		if keys[node.List[0].Value] {
+			return false
+		}
+		keys[node.List[0].Value] = true
+	}
+	return true
+}
+
+func (w *jsonWriter) writeString(s string) {
+	w.enc.Encode(s)
+	w.buf.Truncate(w.buf.Len() - 1) // Encode adds '\n'
+}
+
+// Writes a tree as a JSON value. The shape of the schema value decides what
+// the tree is, heuristics are used where the schema is nil: a bare scalar
+// may be a number, a boolean or null, a sequence of (key value...) lists is
+// an object.
+func (w *jsonWriter) writeTree(tree []Node, schema interface{}) error {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		return w.writeObject(tree, s)
+	case []interface{}:
+		var elem interface{}
+		if len(s) != 0 {
+			elem = s[0]
+		}
+		return w.writeArray(tree, elem)
+	case nil:
+		if isTreeScalar(tree) {
+			n := tree[0]
+			switch {
+			case n.Kind != Scalar:
+				w.writeString(n.Value)
+			case n.Value == "true", n.Value == "false", n.Value == "null", isJSONNumber(n.Value):
+				w.buf.WriteString(n.Value)
+			default:
+				w.writeString(n.Value)
+			}
+			return nil
+		}
+		if t := indirectMap(tree); len(t) != 0 && isObjectTree(t) {
+			return w.writeObject(tree, nil)
+		}
+		return w.writeArray(tree, nil)
+	}
+
+	if !isTreeScalar(tree) {
+		return treeError(tree, "scalar expected")
+	}
+	v := tree[0].Value
+	switch schema.(type) {
+	case string:
+		w.writeString(v)
+	case json.Number:
+		if !isJSONNumber(v) {
+			return treeError(tree, fmt.Sprintf("number expected, got %q", v))
+		}
+		w.buf.WriteString(v)
+	case bool:
+		if v != "true" && v != "false" {
+			return treeError(tree, fmt.Sprintf("boolean expected, got %q", v))
+		}
+		w.buf.WriteString(v)
+	}
+	return nil
+}
+
+func (w *jsonWriter) writeObject(tree []Node, schema map[string]interface{}) error {
+	w.buf.WriteByte('{')
+	for i, node := range indirectMap(tree) {
+		if len(node.List) < 2 || !node.List[0].IsScalar() {
+			return treeError([]Node{node}, "(key value...) list expected")
+		}
+		if i != 0 {
+			w.buf.WriteByte(',')
+		}
+		key := node.List[0].Value
+		w.writeString(key)
+		w.buf.WriteByte(':')
+		if err := w.writeTree(node.List[1:], schema[key]); err != nil {
+			return err
+		}
+	}
+	w.buf.WriteByte('}')
+	return nil
+}
+
+func (w *jsonWriter) writeArray(tree []Node, elem interface{}) error {
+	if isTreeList(tree) {
+		// see unmarshalValue
+		tree = tree[0].List
+	}
+	w.buf.WriteByte('[')
+	for i := range tree {
+		if i != 0 {
+			w.buf.WriteByte(',')
+		}
+		if err := w.writeTree(tree[i:i+1], elem); err != nil {
+			return err
+		}
+	}
+	w.buf.WriteByte(']')
+	return nil
+}
+
+// ToJSON converts sx nodes to an indented JSON document, the nodes are taken
+// as a whole. It's the reverse of FromJSON: (key value...) lists become
+// objects, bare scalars which look like numbers, booleans or null become
+// such. Sx alone is ambiguous, a single-element array looks like a scalar,
+// hence there is an optional schema: a JSON document of the same shape, for
+// example a sample one. Objects, arrays and scalar types of the schema are
+// enforced, the first element of an array is a schema for all elements,
+// heuristics are used for everything not in the schema.
+func ToJSON(nodes []Node, schema []byte) ([]byte, error) {
+	var s interface{}
+	if schema != nil {
+		dec := json.NewDecoder(bytes.NewReader(schema))
+		dec.UseNumber()
+		if err := dec.Decode(&s); err != nil {
+			return nil, fmt.Errorf("invalid schema: %s", err)
+		}
+	}
+	var w jsonWriter
+	w.enc = json.NewEncoder(&w.buf)
+	w.enc.SetEscapeHTML(false)
+	if err := w.writeTree(nodes, s); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, w.buf.Bytes(), "", "    "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
@@ -0,0 +1,183 @@
+package sx
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+var fromJSONCases = []struct {
+	input    string
+	expected string
+	valid    bool
+}{
+	{`{"id": "app", "cpus": 1.5, "instances": 3, "enabled": true, "cmd": null}`,
+		"(id app)\n(cpus 1.5)\n(instances 3)\n(enabled true)\n(cmd null)\n", true},
+	{`{"version": "1.5", "port": "8080", "flag": "true", "none": "null", "empty": ""}`,
+		"(version \"1.5\")\n(port \"8080\")\n(flag \"true\")\n(none \"null\")\n(empty \"\")\n", true},
+	{`{"args": ["/bin/sh", "-c", "env && sleep 300"], "ports": [], "labels": {}}`,
+		"(args /bin/sh -c \"env && sleep 300\")\n(ports ())\n(labels ())\n", true},
+	{`{"env": {"PATH": "/bin"}, "matrix": [[1, 2]], "checks": [{"path": "/"}, {"path": "/health"}]}`,
+		"(env\n    (PATH /bin)\n)\n(matrix (\n    (1 2)\n))\n(checks\n    (\n        (path /)\n    )\n    (\n        (path /health)\n    )\n)\n", true},
+	{`{"a": [[], 1], "b": [[]], "c": [{}, {}]}`, "(a () 1)\n(b\n    (())\n)\n(c () ())\n", true},
+	{`[1, [2, 3]]`, "1\n(2 3)\n", true},
+	{`[[1, 2]]`, "(1 2)\n", true},
+	{`[]`, "", true},
+	{`{}`, "", true},
+	{`"hello world"`, "\"hello world\"\n", true},
+	{`{"a": 1`, "", false},
+	{`{"a": 1} 2`, "", false},
+}
+
+func TestFromJSON(t *testing.T) {
+	for i, c := range fromJSONCases {
+		out, err := FromJSON([]byte(c.input))
+		if err != nil && c.valid {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if err == nil && !c.valid {
+			t.Errorf("case %d, expected an error", i)
+			continue
+		}
+		if c.valid && string(out) != c.expected {
+			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, out, c.expected)
+		}
+	}
+
+	// testdata/marathon-app.json is a hand-written JSON equivalent of
+	// testdata/marathon.sx, unlike testdata/marathon.json, which is the
+	// array-of-arrays output of sxtojson
+	jsondata, err := ioutil.ReadFile("testdata/marathon-app.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sxdata, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := FromJSON(jsondata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Parse(sxdata)
+	if !reflect.DeepEqual(stripSourceInfo(a), stripSourceInfo(b)) {
+		t.Errorf("trees of converted marathon-app.json and marathon.sx differ, got:\n%s", out)
+	}
+}
+
+var toJSONCases = []struct {
+	input    string
+	schema   string
+	expected string
+	valid    bool
+}{
+	{`(a 1) (b true) (c null) (d "1") (e x) (f 1.) (g -0.5e3) (h "a && b")`, "",
+		`{"a":1,"b":true,"c":null,"d":"1","e":"x","f":"1.","g":-0.5e3,"h":"a && b"}`, true},
+	{`(a (b 1) (c 2 3)) (d ())`, "", `{"a":{"b":1,"c":[2,3]},"d":[]}`, true},
+	{`(a 1 2) (a 3)`, "", `[{"a":[1,2]},{"a":3}]`, true},
+	{`1 2 3`, "", `[1,2,3]`, true},
+	{`(a ((b 1)) ((b 2)))`, "", `{"a":[{"b":1},{"b":2}]}`, true},
+	{`(uris x) (port 8080) (on true)`, `{"uris": [""], "port": "", "on": false}`, `{"uris":["x"],"port":"8080","on":true}`, true},
+	{`(c ((a b c)))`, `{"c": [[""]]}`, `{"c":[["a","b","c"]]}`, true},
+	{`(c (a b c))`, `{"c": []}`, `{"c":["a","b","c"]}`, true},
+	{`(a (b 1))`, `{"a": {}}`, `{"a":{"b":1}}`, true},
+	{`(a b)`, `[]`, `["a","b"]`, true},
+	{`(port abc)`, `{"port": 0}`, ``, false},
+	{`(on yes)`, `{"on": false}`, ``, false},
+	{`(a (b c))`, `{"a": ""}`, ``, false},
+	{`(a b) c`, `{}`, ``, false},
+}
+
+func TestToJSON(t *testing.T) {
+	for i, c := range toJSONCases {
+		nodes, err := Parse([]byte(c.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var schema []byte
+		if c.schema != "" {
+			schema = []byte(c.schema)
+		}
+		out, err := ToJSON(nodes, schema)
+		if err != nil && c.valid {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if err == nil && !c.valid {
+			t.Errorf("case %d, expected an error", i)
+			continue
+		}
+		if !c.valid {
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, out); err != nil {
+			t.Errorf("case %d, invalid json: %s", i, err)
+			continue
+		}
+		if compact.String() != c.expected {
+			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, compact.String(), c.expected)
+		}
+	}
+
+	// marathon.sx with marathon-app.json as a schema produces the same
+	// document
+	jsondata, err := ioutil.ReadFile("testdata/marathon-app.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sxdata, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := Parse(sxdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ToJSON(nodes, jsondata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b interface{}
+	if err := json.Unmarshal(out, &a); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(jsondata, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("converted marathon.sx differs from marathon-app.json, got:\n%s", out)
+	}
+}
+
+func TestJSONFormatRoundTrip(t *testing.T) {
+	input := `{"port":"8080","flag":"true","none":"null","count":3,"on":false,"name":"app","a":[[],1]}`
+	sx, err := FromJSON([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := Format(sx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := Parse(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ToJSON(nodes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, out); err != nil {
+		t.Fatal(err)
+	}
+	if compact.String() != input {
+		t.Errorf("got:\n%s\nexpected:\n%s", compact.String(), input)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/sx"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Printf("usage: %s <json file>\n", os.Args[0])
+		os.Exit(1)
+	}
+	data, err := ioutil.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatalf("error reading file: %s", err)
+	}
+
+	out, err := sx.FromJSON(data)
+	if err != nil {
+		log.Fatalf("error converting json to sx: %s", err)
+	}
+
+	os.Stdout.Write(out)
+}
@@ -0,0 +1,207 @@
+package sx
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Marshaler interface {
+	MarshalSX() ([]Node, error)
+}
+
+// Converts a tree to a single node, the tree must be non-empty. An empty list
+// stands for an empty container on its own, see emptyTree.
+func treeToNode(tree []Node) Node {
+	if isTreeScalar(tree) || isTreeList(tree) && len(tree[0].List) == 0 {
+		return tree[0]
+	}
+	return Node{List: tree, Kind: List}
+}
+
+// An empty list, it is used to represent empty containers, because a field
+// list must contain at least two items.
+func emptyTree() []Node {
+	return []Node{{List: []Node{}, Kind: List}}
+}
+
+// Tries Marshaler first, then encoding.TextMarshaler, which produces a
+// scalar.
+func tryMarshaler(v reflect.Value) (bool, []Node, error) {
+	m, ok := v.Interface().(Marshaler)
+	if !ok {
+		// T doesn't work, try *T as well
+		if v.Kind() != reflect.Ptr && v.CanAddr() {
+			m, ok = v.Addr().Interface().(Marshaler)
+		}
+	}
+
+	if ok {
+		tree, err := m.MarshalSX()
+		return true, tree, err
+	}
+
+	tm, ok := v.Interface().(encoding.TextMarshaler)
+	if !ok {
+		if v.Kind() != reflect.Ptr && v.CanAddr() {
+			tm, ok = v.Addr().Interface().(encoding.TextMarshaler)
+		}
+	}
+
+	if ok {
+		text, err := tm.MarshalText()
+		if err != nil {
+			return true, nil, err
+		}
+		return true, []Node{{Value: string(text)}}, nil
+	}
+	return false, nil, nil
+}
+
+// Same rules as in encoding/json: false, 0, a nil pointer, a nil interface
+// value and any empty array, slice, map or string.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Returns a tree which is the representation of 'v'. Nil pointers, slices,
+// maps and interfaces produce nil trees, containers which contain them skip
+// such elements or fail.
+func marshalValue(v reflect.Value) ([]Node, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+
+	if ok, tree, err := tryMarshaler(v); ok {
+		return tree, err
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(v.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			return []Node{{Value: time.Duration(v.Int()).String()}}, nil
+		}
+		return []Node{{Value: strconv.FormatInt(v.Int(), 10)}}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []Node{{Value: strconv.FormatUint(v.Uint(), 10)}}, nil
+	case reflect.Float32, reflect.Float64:
+		return []Node{{Value: strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())}}, nil
+	case reflect.Bool:
+		return []Node{{Value: strconv.FormatBool(v.Bool())}}, nil
+	case reflect.String:
+		return []Node{{Value: v.String()}}, nil
+	case reflect.Array, reflect.Slice:
+		if v.Len() == 0 {
+			return emptyTree(), nil
+		}
+		out := make([]Node, 0, v.Len())
+		for i, n := 0, v.Len(); i < n; i++ {
+			tree, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			if len(tree) == 0 {
+				return nil, fmt.Errorf("cannot marshal nil element of a slice or an array")
+			}
+			out = append(out, treeToNode(tree))
+		}
+		if isTreeList(out) {
+			// Unmarshal performs an indirection for cases like this:
+			//   (a (1 2 3)) vs (a 1 2 3)
+			// Hence a single list element needs an extra list around it.
+			out = []Node{{List: out, Kind: List}}
+		}
+		return out, nil
+	case reflect.Map:
+		out := []Node{}
+		for _, key := range v.MapKeys() {
+			ktree, err := marshalValue(key)
+			if err != nil {
+				return nil, fmt.Errorf("key marshaling failure: %s", err)
+			}
+			if !isTreeScalar(ktree) {
+				return nil, fmt.Errorf("map key must be represented via a scalar")
+			}
+			vtree, err := marshalValue(v.MapIndex(key))
+			if err != nil {
+				return nil, fmt.Errorf("value marshaling failure: %s", err)
+			}
+			if len(vtree) == 0 {
+				continue
+			}
+			out = append(out, Node{List: append(ktree, vtree...), Kind: List})
+		}
+		if len(out) == 0 {
+			return emptyTree(), nil
+		}
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].List[0].Value < out[j].List[0].Value
+		})
+		return out, nil
+	case reflect.Struct:
+		out := []Node{}
+		for _, f := range cachedStructPlan(v.Type()).fields {
+			if f.unexported {
+				continue
+			}
+			fv, ok := fieldByIndexNoAlloc(v, f.index)
+			if !ok || f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			tree, err := marshalValue(fv)
+			if err != nil {
+				return nil, err
+			}
+			if len(tree) == 0 {
+				continue
+			}
+			out = append(out, Node{List: append([]Node{{Value: f.name}}, tree...), Kind: List})
+		}
+		if len(out) == 0 {
+			return emptyTree(), nil
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported type")
+}
+
+// Marshal returns sx representation of 'v'. It uses the same conventions and
+// struct tags as Unmarshal, values of types implementing Marshaler interface
+// are represented by their own trees.
+func Marshal(v interface{}) ([]byte, error) {
+	tree, err := marshalValue(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	var p printer
+	p.writeNodes(tree)
+	return p.buf.Bytes(), nil
+}
@@ -0,0 +1,190 @@
+package sx
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func (v Vec3) MarshalSX() ([]Node, error) {
+	return []Node{
+		{Value: strconv.FormatFloat(v.X, 'g', -1, 64)},
+		{Value: strconv.FormatFloat(v.Y, 'g', -1, 64)},
+		{Value: strconv.FormatFloat(v.Z, 'g', -1, 64)},
+	}, nil
+}
+
+type Quoted []string
+
+func (q Quoted) MarshalSX() ([]Node, error) {
+	kinds := []Kind{String, RawString, MultiLine, Scalar}
+	out := []Node{}
+	for i, s := range q {
+		out = append(out, Node{Value: s, Kind: kinds[i%len(kinds)]})
+	}
+	return out, nil
+}
+
+type SMarshal1 struct {
+	Name     string `sx:"name"`
+	Position Vec3   `sx:"pos"`
+	Parent   *S1    `sx:"parent"`
+	Skip     int    `sx:"-"`
+	private  int
+	Tags     []string `sx:"tags"`
+}
+
+type SMarshal2 struct {
+	Matrix [][]int
+	Items  []SValidSimple
+}
+
+type SOmitEmpty struct {
+	Name    string   `sx:"name,omitempty"`
+	Count   int      `sx:"count,omitempty"`
+	Tags    []string `sx:"tags,omitempty"`
+	Enabled bool     `sx:"enabled,omitempty"`
+}
+
+var marshalCases = []struct {
+	input    interface{}
+	expected string
+	valid    bool
+}{
+	{&SValidSimple{"nsf", "no.smile.face@gmail.com"}, "(name nsf)\n(email no.smile.face@gmail.com)\n", true},
+	{SValidSimple{"", "a b"}, "(name \"\")\n(email \"a b\")\n", true},
+	{&S1{}, "()\n", true},
+	{&S1{stringPtr(`C:\Windows`)}, "(Field C:\\Windows)\n", true},
+	{&S1{stringPtr(`C:\Program Files`)}, "(Field `C:\\Program Files`)\n", true},
+	{&S1{stringPtr("\"`\x00")}, "(Field \"\\\"`\\x00\")\n", true},
+	{&S1{stringPtr("hello\n\nworld")}, "(Field `\n    | hello\n    |\n    | world\n`)\n", true},
+	{&S1{stringPtr("\nhello")}, "(Field \"\\nhello\")\n", true},
+	{&S1{stringPtr("\x00\n\x7F")}, "(Field \"\\x00\\n\\x7F\")\n", true},
+	{&S2{-5}, "(Int -5)\n", true},
+	{&S3{5}, "(Uint 5)\n", true},
+	{&S4{0.5}, "(Float 0.5)\n", true},
+	{&S5{true}, "(Bool true)\n", true},
+	{&S6{[]string{"abc", "def"}}, "(Values abc def)\n", true},
+	{&S6{[]string{}}, "(Values ())\n", true},
+	{&S6{}, "()\n", true},
+	{&S7{[4]int{1, 2, 3, 4}}, "(Ints 1 2 3 4)\n", true},
+	{&S8{map[string]bool{"view": false, "edit": true}}, "(Map\n    (edit true)\n    (view false)\n)\n", true},
+	{&S8{map[string]bool{}}, "(Map ())\n", true},
+	{&SChan{make(chan int)}, "", false},
+	{&SMarshal1{Name: "a", Position: Vec3{1, 2, 3.5}, Skip: 1, private: 2}, "(name a)\n(pos 1 2 3.5)\n", true},
+	{&SMarshal2{Matrix: [][]int{{1, 2}}}, "(Matrix (\n    (1 2)\n))\n", true},
+	{&SMarshal2{Matrix: [][]int{{1}, {2, 3}}, Items: []SValidSimple{{"a", "b"}}}, "(Matrix\n    1\n    (2 3)\n)\n(Items (\n    (\n        (name a)\n        (email b)\n    )\n))\n", true},
+	{&SMarshal2{Matrix: [][]int{{}, {1}}}, "(Matrix () 1)\n", true},
+	{&SMarshal2{Matrix: [][]int{{}}}, "(Matrix\n    (())\n)\n", true},
+	{&SMarshal2{Matrix: [][]int{{}, {}}, Items: []SValidSimple{{}}}, "(Matrix () ())\n(Items (\n    (\n        (name \"\")\n        (email \"\")\n    )\n))\n", true},
+	{[]int{1, 2, 3}, "1\n2\n3\n", true},
+	{&SEmbedded{CommonOptions{"", true}, &LogOptions{Level: "debug"}, secretOptions{"xyz"}, 80}, "(verbose true)\n(level debug)\n(token xyz)\n(port 80)\n", true},
+	{&SEmbedded{Port: 80}, "(verbose false)\n(token \"\")\n(port 80)\n", true},
+	{&SEmbeddedTwice{embeddedXA{EmbeddedX{1}}, embeddedXB{EmbeddedX{2}}}, "()\n", true},
+	{Quoted{"1", "2", "3\n4", "5", "\n", "`", "7", "8"}, "\"1\"\n`2`\n`\n    | 3\n    | 4\n`\n5\n\"\\n\"\n\"`\"\n\"7\"\n8\n", true},
+	{Vec3{1, 2, 3}, "1\n2\n3\n", true},
+	{&STextual{IP: net.IPv4(10, 0, 0, 1), Big: big.NewInt(-5), Hosts: map[netip.Addr]string{netip.IPv6Loopback(): "localhost"}},
+		"(IP 10.0.0.1)\n(Prefix \"\")\n(Big -5)\n(Hosts\n    (::1 localhost)\n)\n", true},
+	{&SLimits{Timeout: 90 * time.Second, Deadline: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC), Memory: 1536 * MiB},
+		"(Timeout 1m30s)\n(Deadline 2017-03-01T12:00:00Z)\n(Memory 1536MiB)\n", true},
+	{&SOmitEmpty{Name: "a"}, "(name a)\n", true},
+	{&SOmitEmpty{Count: 1, Tags: []string{"x"}, Enabled: true}, "(count 1)\n(tags x)\n(enabled true)\n", true},
+	{&SOmitEmpty{}, "()\n", true},
+}
+
+func TestMarshal(t *testing.T) {
+	for i, c := range marshalCases {
+		data, err := Marshal(c.input)
+		if err != nil && c.valid {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if err == nil && !c.valid {
+			t.Errorf("case %d, expected an error", i)
+			continue
+		}
+		if c.valid && string(data) != c.expected {
+			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, data, c.expected)
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for i, c := range marshalCases {
+		if !c.valid {
+			continue
+		}
+		data, err := Marshal(c.input)
+		if err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		in := reflect.ValueOf(c.input)
+		if in.Kind() == reflect.Ptr {
+			in = in.Elem()
+		}
+		out := reflect.New(in.Type())
+		if err := Unmarshal(data, out.Interface()); err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if in.Kind() == reflect.Struct && !reflect.DeepEqual(exportedFields(in), exportedFields(out.Elem())) {
+			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, prettyPrintAsJson(out.Interface()), prettyPrintAsJson(c.input))
+		}
+	}
+
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b MarathonConfig
+	if err := Unmarshal(data, &a); err != nil {
+		t.Fatal(err)
+	}
+	data, err = Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("marathon config doesn't survive a round trip:\n%s", data)
+	}
+}
+
+// values of fields which survive a round trip
+func exportedFields(v reflect.Value) []interface{} {
+	var out []interface{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("sx") == "-" {
+			continue
+		}
+		out = append(out, v.Field(i).Interface())
+	}
+	return out
+}
+
+func BenchmarkMarshalMarathon(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var config MarathonConfig
+	if err := Unmarshal(data, &config); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(&config); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,22 +1,152 @@
 package sx
 
 import (
-	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+	"unsafe"
 )
 
 //----------------------------------------------------------------------------
 // ast node
 //----------------------------------------------------------------------------
 
+// Kind is a source form of a node. It doesn't affect the meaning of a node,
+// but tools may use it to re-emit values faithfully.
+type Kind int
+
+const (
+	Scalar    Kind = iota // bare word: hello
+	String                // "hello"
+	RawString             // `hello`
+	MultiLine             // `\n| hello\n`
+	List                  // (hello)
+)
+
+var kindNames = [...]string{
+	Scalar:    "scalar",
+	String:    "string",
+	RawString: "raw string",
+	MultiLine: "multi-line string",
+	List:      "list",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
 type Node struct {
 	List  []Node
 	Value string
+	Kind  Kind
+	Start Pos // position of the first byte of the node
+	End   Pos // position right after the last byte of the node
 }
 
 func (n *Node) IsScalar() bool {
 	return n.List == nil
 }
 
+//----------------------------------------------------------------------------
+// source position
+//----------------------------------------------------------------------------
+
+// Pos is a position in the source data. Offset is a zero-based byte offset,
+// Line and Column start from 1. Column is counted in bytes.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+//----------------------------------------------------------------------------
+// syntax error
+//----------------------------------------------------------------------------
+
+// SyntaxError is returned by Parse when the input is not a valid sx document.
+type SyntaxError struct {
+	Msg     string
+	Pos     Pos
+	Excerpt string // the source line containing the error, possibly trimmed
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList is a list of syntax errors, it is returned when the parser is
+// asked to report all errors.
+type ErrorList []*SyntaxError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+//----------------------------------------------------------------------------
+// limit error
+//----------------------------------------------------------------------------
+
+// Lists nested deeper than that overflow the stack, hence the parser always
+// has a depth limit.
+const defaultMaxDepth = 10000
+
+// LimitError is returned by the parser when the input goes over one of the
+// limits set by ParseOptions or DecoderLimits. Limit errors stop the parser
+// even when ParseOptions.AllErrors is set.
+type LimitError struct {
+	Limit string // name of the limit: MaxDepth, MaxNodes, MaxValueBytes or MaxNodeBytes
+	Max   int    // value of the limit
+	Pos   Pos    // position of the node which goes over the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %d exceeded", e.Pos, e.Limit, e.Max)
+}
+
+const maxExcerptLen = 60
+
+// Returns the line containing 'offset', trimmed to at most 'maxExcerptLen'
+// bytes around the offset.
+func excerpt(data []byte, offset int) string {
+	begin := offset
+	for begin > 0 && data[begin-1] != '\n' {
+		begin--
+	}
+	end := offset
+	for end < len(data) && data[end] != '\n' {
+		end++
+	}
+	if end > begin && data[end-1] == '\r' {
+		end--
+	}
+
+	prefix, suffix := "", ""
+	if end-begin > maxExcerptLen {
+		if offset-begin > maxExcerptLen/2 {
+			begin = offset - maxExcerptLen/2
+			prefix = "..."
+		}
+		if end-begin > maxExcerptLen {
+			end = begin + maxExcerptLen
+			suffix = "..."
+		}
+	}
+	return prefix + string(data[begin:end]) + suffix
+}
+
 //----------------------------------------------------------------------------
 // parser
 //----------------------------------------------------------------------------
@@ -52,17 +182,146 @@ func isHex(b int) (int, bool) {
 const eof int = -1
 
 type parser struct {
-	data []byte
-	ptr  int // pointer into 'data'
-	err  error
+	data      []byte
+	ptr       int   // pointer into 'data'
+	base      int   // offset of 'data' in the whole input
+	line      int   // current line number
+	lineStart int   // offset of the first byte of the current line in 'data'
+	err       error // the first error
+
+	// the error was caused by the end of data, more data may fix it
+	unexpectedEOF bool
+
+	// In recovery mode errors don't stop the parser, they are collected
+	// instead and the parser skips input up to the next synchronization
+	// point.
+	recover bool
+	errors  ErrorList
+
+	// Values without escape sequences share memory with 'data'.
+	noCopy bool
+
+	// Elements of the lists which are being parsed, every list is copied
+	// out of the stack once it's complete, so that it's allocated once.
+	stack []Node
+
+	// limits, zero means no limit
+	maxDepth      int
+	maxNodes      int
+	maxValueBytes int
+	depth         int // current list nesting depth
+	nodes         int // number of nodes parsed so far
+}
+
+func newParser(data []byte) *parser {
+	return &parser{data: data, line: 1, maxDepth: defaultMaxDepth}
+}
+
+// Parser for a chunk of a bigger input, which starts at 'base' position.
+func newParserAt(data []byte, base Pos) *parser {
+	return &parser{
+		data:      data,
+		base:      base.Offset,
+		line:      base.Line,
+		lineStart: 1 - base.Column,
+		maxDepth:  defaultMaxDepth,
+	}
+}
+
+// current position
+func (p *parser) pos() Pos {
+	return Pos{Offset: p.base + p.ptr, Line: p.line, Column: p.ptr - p.lineStart + 1}
 }
 
 func (p *parser) error(msg string) int {
-	p.ptr = len(p.data)
-	p.err = errors.New(msg)
+	return p.errorAt(p.pos(), msg)
+}
+
+func (p *parser) errorAt(pos Pos, msg string) int {
+	err := &SyntaxError{
+		Msg:     msg,
+		Pos:     pos,
+		Excerpt: excerpt(p.data, pos.Offset-p.base),
+	}
+	if p.err == nil {
+		p.err = err
+	}
+	if p.recover {
+		p.errors = append(p.errors, err)
+	} else {
+		p.ptr = len(p.data)
+	}
 	return eof
 }
 
+// Skips input up to the next line or a closing parenthesis, whatever comes
+// first. The closing parenthesis is left unread.
+func (p *parser) sync() {
+	for b := p.current(); b != eof && b != ')'; b = p.advance() {
+		if b == '\n' {
+			p.advance()
+			return
+		}
+	}
+}
+
+// Limit errors are fatal even in recovery mode.
+func (p *parser) limitError(limit string, max int, pos Pos) {
+	p.err = &LimitError{Limit: limit, Max: max, Pos: pos}
+	p.recover = false
+	p.ptr = len(p.data)
+}
+
+// Counts a node which is about to be parsed, returns false if there are too
+// many of them.
+func (p *parser) countNode() bool {
+	p.nodes++
+	if p.maxNodes > 0 && p.nodes > p.maxNodes {
+		p.limitError("MaxNodes", p.maxNodes, p.pos())
+		return false
+	}
+	return true
+}
+
+// Enters a list at the current position, returns false if lists are nested
+// too deep. Must be paired with leaveList.
+func (p *parser) enterList() bool {
+	p.depth++
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		p.limitError("MaxDepth", p.maxDepth, p.pos())
+		return false
+	}
+	return true
+}
+
+func (p *parser) leaveList() {
+	p.depth--
+}
+
+// Zero limits keep the defaults.
+func (p *parser) setLimits(maxDepth, maxNodes, maxValueBytes int) {
+	if maxDepth > 0 {
+		p.maxDepth = maxDepth
+	}
+	p.maxNodes = maxNodes
+	p.maxValueBytes = maxValueBytes
+}
+
+// Returns false if a value of 'size' bytes is too big. Values are checked
+// while they are scanned, so that a huge one is not copied first.
+func (p *parser) checkValueSize(size int, start Pos) bool {
+	if p.maxValueBytes > 0 && size > p.maxValueBytes {
+		p.limitError("MaxValueBytes", p.maxValueBytes, start)
+		return false
+	}
+	return true
+}
+
+func (p *parser) eofError(msg string) int {
+	p.unexpectedEOF = true
+	return p.error(msg)
+}
+
 // current byte or EOF
 func (p *parser) current() int {
 	if p.ptr == len(p.data) {
@@ -76,21 +335,24 @@ func (p *parser) next(n int) int {
 	if p.ptr+n >= len(p.data) {
 		return eof
 	}
-	return int(p.data[p.ptr])
+	return int(p.data[p.ptr+n])
 }
 
 // increment pointer and return current byte or EOF
 func (p *parser) advance() int {
 	if p.ptr < len(p.data) {
+		if p.data[p.ptr] == '\n' {
+			p.line++
+			p.lineStart = p.ptr + 1
+		}
 		p.ptr++
 	}
 	return p.current()
 }
 
 func (p *parser) advanceN(n int) int {
-	p.ptr += n
-	if p.ptr > len(p.data) {
-		p.ptr = len(p.data)
+	for i := 0; i < n; i++ {
+		p.advance()
 	}
 	return p.current()
 }
@@ -124,103 +386,185 @@ func (p *parser) matches(s string) bool {
 	return true
 }
 
-func (p *parser) parseScalar() Node {
-	buf := []byte{}
+// Returns data[begin:end] as a string, which shares memory with data in
+// no-copy mode.
+func (p *parser) str(begin, end int) string {
+	if p.noCopy && end > begin {
+		return unsafe.String(&p.data[begin], end-begin)
+	}
+	return string(p.data[begin:end])
+}
+
+func (p *parser) parseScalar() (Node, bool) {
+	start := p.pos()
+	begin := p.ptr
 	for b := p.current(); b != eof && isScalar(b); b = p.advance() {
-		buf = append(buf, byte(b))
+		if !p.checkValueSize(p.ptr-begin+1, start) {
+			return Node{}, false
+		}
 	}
-	return Node{Value: string(buf)}
+	return Node{Value: p.str(begin, p.ptr), Kind: Scalar, Start: start, End: p.pos()}, true
 }
 
-// All known escape sequences are single bytes.
-// Sets an error and returns 'eof' on invalid escape sequence.
+// Appends the value of an escape sequence to 'buf'. Sets an error and returns
+// false on invalid escape sequence.
 //
 // Expects pointer at opening `\`, leaves pointer at the last character of
 // escape sequence.
-func (p *parser) parseEscapeSequence() int {
+func (p *parser) parseEscapeSequence(buf []byte) ([]byte, bool) {
+	start := p.pos()
 	b := p.advance() // step into the literal from `\`
 	switch b {
 	case '"':
-		return '"'
+		return append(buf, '"'), true
 	case '\\':
-		return '\\'
+		return append(buf, '\\'), true
 	case 'r':
-		return '\r'
+		return append(buf, '\r'), true
 	case 'n':
-		return '\n'
+		return append(buf, '\n'), true
 	case 't':
-		return '\t'
+		return append(buf, '\t'), true
 	case 'x':
 		// raw byte: \xFF
 		if p.unreadLen() < 3 {
-			return p.error("unexpected eof when parsing a string escape sequence (hex literal)")
+			p.eofError("unexpected eof when parsing a string escape sequence (hex literal)")
+			return buf, false
 		}
 		a, ok := isHex(int(p.data[p.ptr+1]))
 		if !ok {
-			return p.error("invalid first hex digit in string escape sequence")
+			p.errorAt(start, "invalid first hex digit in string escape sequence")
+			return buf, false
 		}
 		b, ok := isHex(int(p.data[p.ptr+2]))
 		if !ok {
-			return p.error("invalid second hex digit in string escape sequence")
+			p.errorAt(start, "invalid second hex digit in string escape sequence")
+			return buf, false
 		}
 		p.advanceN(2) // put pointer to the last character of sequence
-		return a*16 + b
+		return append(buf, byte(a*16+b)), true
+	case 'u':
+		// unicode code point encoded as UTF-8: \u{1F600}
+		if p.advance() != '{' {
+			if p.current() == eof {
+				p.eofError("unexpected eof when parsing a string escape sequence (unicode code point)")
+			} else {
+				p.errorAt(start, "invalid unicode escape sequence, '{' expected")
+			}
+			return buf, false
+		}
+		r, digits := 0, 0
+		for b := p.advance(); b != '}'; b = p.advance() {
+			if b == eof {
+				p.eofError("unexpected eof when parsing a string escape sequence (unicode code point)")
+				return buf, false
+			}
+			h, ok := isHex(b)
+			if !ok {
+				p.errorAt(start, "invalid hex digit in unicode escape sequence")
+				return buf, false
+			}
+			if digits++; digits > 6 {
+				p.errorAt(start, "too many hex digits in unicode escape sequence")
+				return buf, false
+			}
+			r = r*16 + h
+		}
+		if digits == 0 {
+			p.errorAt(start, "empty unicode escape sequence")
+			return buf, false
+		}
+		if !utf8.ValidRune(rune(r)) {
+			p.errorAt(start, fmt.Sprintf("invalid unicode code point U+%04X in escape sequence", r))
+			return buf, false
+		}
+		return utf8.AppendRune(buf, rune(r)), true
 	default:
 		if b == eof {
-			return p.error("unexpected eof when parsing a string escape sequence")
+			p.eofError("unexpected eof when parsing a string escape sequence")
 		} else {
-			return p.error("invalid escape sequence")
+			p.errorAt(start, "invalid escape sequence")
 		}
+		return buf, false
 	}
 }
 
 // Expects pointer at opening `"`, leaves pointer at the next character after
 // closing `"`.
 func (p *parser) parseStringLiteral() (Node, bool) {
-	buf := []byte{}
+	start := p.pos()
+	begin := p.ptr + 1
+
+	// the buffer is used only after the first escape sequence
+	var buf []byte
+	escaped := false
 	for b := p.advance(); b != eof; b = p.advance() {
 		switch b {
 		case '\\':
-			b = p.parseEscapeSequence()
-			if b == eof {
+			if !escaped {
+				buf = append(buf, p.data[begin:p.ptr]...)
+				escaped = true
+			}
+			var ok bool
+			if buf, ok = p.parseEscapeSequence(buf); !ok {
+				return Node{}, false
+			}
+			if !p.checkValueSize(len(buf), start) {
 				return Node{}, false
 			}
-			fallthrough
 		default:
-			buf = append(buf, byte(b))
+			if escaped {
+				buf = append(buf, byte(b))
+			}
+			size := len(buf)
+			if !escaped {
+				size = p.ptr - begin + 1
+			}
+			if !p.checkValueSize(size, start) {
+				return Node{}, false
+			}
 		case '"':
+			value := string(buf)
+			if !escaped {
+				value = p.str(begin, p.ptr)
+			}
 			p.advance()
-			return Node{Value: string(buf)}, true
+			return Node{Value: value, Kind: String, Start: start, End: p.pos()}, true
 		case '\n':
 			p.error(`unexpected '\n' in a string literal, allowed in multi-line strings only`)
 			return Node{}, false
 		}
 	}
-	p.error(`unexpected eof, missing terminating '"' in a string literal`)
+	p.eofError(`unexpected eof, missing terminating '"' in a string literal`)
 	return Node{}, false
 }
 
 // Expects pointer at opening '`', leaves pointer at the next character after
 // closing '`'.
 func (p *parser) parseRawStringLiteral() (Node, bool) {
-	buf := []byte{}
+	start := p.pos()
+	begin := p.ptr + 1
 	for b := p.advance(); b != eof; b = p.advance() {
 		switch b {
 		case '`':
+			value := p.str(begin, p.ptr)
 			p.advance()
-			return Node{Value: string(buf)}, true
+			return Node{Value: value, Kind: RawString, Start: start, End: p.pos()}, true
 		case '\n':
 			p.error(`unexpected '\n' in a raw string literal, allowed in multi-line strings only`)
 			return Node{}, false
 		default:
-			buf = append(buf, byte(b))
+			if !p.checkValueSize(p.ptr-begin+1, start) {
+				return Node{}, false
+			}
 		}
 	}
-	p.error("unexpected eof, missing terminating '`' in a raw string literal")
+	p.eofError("unexpected eof, missing terminating '`' in a raw string literal")
 	return Node{}, false
 }
 
 // Expects pointer at opening '|', leaves pointer at unexpected EOF or '\n'.
+// Stops early if the value gets too big, the caller checks its size.
 func (p *parser) parseRawLine(buf []byte) []byte {
 	if p.advance() == ' ' {
 		p.advance()
@@ -231,6 +575,9 @@ func (p *parser) parseRawLine(buf []byte) []byte {
 			// skip '\r'
 		default:
 			buf = append(buf, byte(b))
+			if p.maxValueBytes > 0 && len(buf) > p.maxValueBytes {
+				return buf
+			}
 		}
 	}
 	return buf
@@ -239,6 +586,7 @@ func (p *parser) parseRawLine(buf []byte) []byte {
 // Expects pointer at opening '`', assuming that the sequence is '`\n' or
 // '`\r\n'. Leaves pointer at the next character after cloing '`'.
 func (p *parser) parseMultiLineStringLiteral() (Node, bool) {
+	start := p.pos()
 	if p.next(1) == '\r' {
 		p.advanceN(3)
 	} else {
@@ -251,15 +599,18 @@ func (p *parser) parseMultiLineStringLiteral() (Node, bool) {
 		switch p.current() {
 		case '`':
 			p.advance()
-			return Node{Value: string(buf)}, true
+			return Node{Value: string(buf), Kind: MultiLine, Start: start, End: p.pos()}, true
 		case '|':
 			if len(buf) != 0 {
 				buf = append(buf, '\n')
 			}
 			buf = p.parseRawLine(buf)
+			if !p.checkValueSize(len(buf), start) {
+				return Node{}, false
+			}
 			p.advance()
 		case eof:
-			p.error("unexpected eof when parsing a multi-line string literal")
+			p.eofError("unexpected eof when parsing a multi-line string literal")
 			return Node{}, false
 		default:
 			p.error("invalid beginning of a string in a multi-line string literal, '`' or '|' expected")
@@ -268,26 +619,50 @@ func (p *parser) parseMultiLineStringLiteral() (Node, bool) {
 	}
 }
 
+// Copies elements of a complete list out of the stack.
+func (p *parser) popList(base int) []Node {
+	out := make([]Node, len(p.stack)-base)
+	copy(out, p.stack[base:])
+	p.stack = p.stack[:base]
+	return out
+}
+
 // Expects pointer at opening '(', leaves pointer at the next character after
 // closing ')'.
 func (p *parser) parseList() (Node, bool) {
-	out := []Node{}
+	defer p.leaveList()
+	if !p.enterList() {
+		return Node{}, false
+	}
+	start := p.pos()
+	base := len(p.stack)
 	p.advance() // skip opening '('
 	for {
 		p.skipToNonSpace()
 		switch p.current() {
 		case eof:
-			p.error("unexpected eof when parsing a list")
+			p.eofError(fmt.Sprintf("unexpected eof when parsing a list opened at %s", start))
+			if p.recover {
+				return Node{List: p.popList(base), Kind: List, Start: start, End: p.pos()}, true
+			}
+			p.stack = p.stack[:base]
 			return Node{}, false
 		case ')':
 			p.advance()
-			return Node{List: out}, true
+			return Node{List: p.popList(base), Kind: List, Start: start, End: p.pos()}, true
+		case ';':
+			p.skipComment()
 		default:
 			node, ok := p.parseSingleNode()
 			if !ok {
-				return Node{}, false
+				if !p.recover {
+					p.stack = p.stack[:base]
+					return Node{}, false
+				}
+				p.sync()
+				continue
 			}
-			out = append(out, node)
+			p.stack = append(p.stack, node)
 		}
 	}
 }
@@ -297,45 +672,132 @@ func (p *parser) parseSingleNode() (Node, bool) {
 		p.skipToNonSpace()
 		switch p.current() {
 		case '(':
+			if !p.countNode() {
+				return Node{}, false
+			}
 			return p.parseList()
 		case ')':
 			p.error("unmatched closing parenthesis ')'")
-			return Node{}, false
-		case '"':
-			return p.parseStringLiteral()
-		case '`':
-			if p.matches("`\n") || p.matches("`\r\n") {
-				return p.parseMultiLineStringLiteral()
-			} else {
-				return p.parseRawStringLiteral()
+			if !p.recover {
+				return Node{}, false
 			}
+			p.advance()
 		case ';':
 			p.skipComment()
-		default:
-			return p.parseScalar(), true
 		case eof:
 			return Node{}, false
+		default:
+			if !p.countNode() {
+				return Node{}, false
+			}
+			return p.parseValue()
 		}
 	}
 }
 
+// Parses a scalar or a string literal.
+func (p *parser) parseValue() (Node, bool) {
+	switch p.current() {
+	case '"':
+		return p.parseStringLiteral()
+	case '`':
+		if p.matches("`\n") || p.matches("`\r\n") {
+			return p.parseMultiLineStringLiteral()
+		} else {
+			return p.parseRawStringLiteral()
+		}
+	default:
+		return p.parseScalar()
+	}
+}
+
 func (p *parser) parse() []Node {
 	var out []Node
 	for {
 		node, ok := p.parseSingleNode()
 		if !ok {
+			if p.recover && p.current() != eof {
+				p.sync()
+				continue
+			}
 			break
 		}
 		out = append(out, node)
 	}
-	if p.err == nil {
+	if p.err == nil || p.recover {
 		return out
 	}
 	return nil
 }
 
+// Parse parses sx data and returns its top-level nodes. On failure the error
+// is a *SyntaxError, or a *LimitError if lists are nested too deep.
 func Parse(data []byte) ([]Node, error) {
-	p := parser{data: data}
+	p := newParser(data)
+	ast := p.parse()
+	return ast, p.err
+}
+
+type ParseOptions struct {
+	// Report all syntax errors instead of stopping at the first one. The
+	// parser resynchronizes at the next line or closing parenthesis after
+	// an error and returns a partial tree along with an ErrorList.
+	AllErrors bool
+
+	// Values without escape sequences share memory with the input instead
+	// of being copied. The input must not be modified while the tree is in
+	// use.
+	NoCopy bool
+
+	// Limits for untrusted input, zero means the default: unlimited nodes
+	// and value size, a depth of 10000 lists. Going over a limit stops the
+	// parser with a *LimitError.
+	MaxDepth      int // maximum nesting depth of lists
+	MaxNodes      int // maximum number of nodes, lists included
+	MaxValueBytes int // maximum size of a value in bytes, after unescaping
+
+	// Input encoding handling. A UTF-16 input is recognized by its byte
+	// order mark and transcoded to UTF-8, positions refer to the
+	// transcoded data then. The same is true for replaced invalid UTF-8
+	// sequences.
+	StripBOM    bool       // strip a UTF-8 byte order mark
+	DetectUTF16 bool       // transcode UTF-16 LE/BE input with a BOM
+	InvalidUTF8 UTF8Policy // what to do with invalid UTF-8
+}
+
+// ParseWithOptions is like Parse, but allows to tune the parser.
+func ParseWithOptions(data []byte, opts ParseOptions) ([]Node, error) {
+	data, ep := prepareInput(data, opts)
+	if ep != nil && !opts.AllErrors {
+		return nil, ep.err
+	}
+
+	p := newParser(data)
+	if ep != nil {
+		p.err = ep.err
+		p.errors = ep.errors
+	}
+	p.recover = opts.AllErrors
+	p.noCopy = opts.NoCopy
+	p.setLimits(opts.MaxDepth, opts.MaxNodes, opts.MaxValueBytes)
 	ast := p.parse()
+	if p.recover {
+		if len(p.errors) == 0 {
+			return ast, nil
+		}
+		if ep != nil {
+			// encoding errors go first, restore the order
+			sort.SliceStable(p.errors, func(i, j int) bool {
+				return p.errors[i].Pos.Offset < p.errors[j].Pos.Offset
+			})
+		}
+		return ast, p.errors
+	}
 	return ast, p.err
 }
+
+// ParseAll parses sx data reporting all syntax errors. It returns a partial
+// tree of everything that was parsed successfully and an ErrorList.
+func ParseAll(data []byte) ([]Node, error) {
+	return ParseWithOptions(data, ParseOptions{AllErrors: true})
+}
@@ -5,7 +5,9 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"unsafe"
 )
 
 func prettyPrint(ast []Node) string {
@@ -19,7 +21,7 @@ func prettyPrint(ast []Node) string {
 func expect(strs ...string) []Node {
 	var nodes []Node
 	for _, str := range strs {
-		nodes = append(nodes, Node{nil, str})
+		nodes = append(nodes, Node{Value: str})
 	}
 	return nodes
 }
@@ -29,9 +31,9 @@ func convertJsonToAst(v []interface{}) []Node {
 	for _, elem := range v {
 		switch e := elem.(type) {
 		case string:
-			out = append(out, Node{nil, e})
+			out = append(out, Node{Value: e})
 		case []interface{}:
-			out = append(out, Node{convertJsonToAst(e), ""})
+			out = append(out, Node{List: convertJsonToAst(e)})
 		default:
 			panic("invalid json type")
 		}
@@ -51,6 +53,22 @@ func convertAstToJson(v []Node) []interface{} {
 	return out
 }
 
+// clears positions and literal kinds, so that trees can be compared with
+// expected ones
+func stripSourceInfo(v []Node) []Node {
+	if v == nil {
+		return nil
+	}
+	out := make([]Node, len(v))
+	for i, elem := range v {
+		if elem.List != nil {
+			out[i].List = stripSourceInfo(elem.List)
+		}
+		out[i].Value = elem.Value
+	}
+	return out
+}
+
 func expectJson(str string) []Node {
 	var out []interface{}
 	err := json.Unmarshal([]byte(str), &out)
@@ -119,6 +137,21 @@ var cases = []struct {
 	{true, "12(34(56`hello`\"world\"))", expectJson(`["12", ["34", ["56", "hello", "world"]]]`)},
 	{true, "()", expectJson(`[[]]`)},
 	{true, `hello(iam"John")world`, expectJson(`["hello", ["iam", "John"], "world"]`)},
+	{false, "(hello ; world", nil},
+
+	// 40
+	{true, `"\u{41}\u{e9}\u{20AC}\u{1F600}"`, expect("Aé€😀")},
+	{true, `"\u{000041}\u{10FFFF}"`, expect("A\U0010FFFF")},
+	{true, "`\\u{41}`", expect(`\u{41}`)},
+	{false, `"\u41"`, nil},
+	{false, `"\u{}"`, nil},
+
+	// 45
+	{false, `"\u{41"`, nil},
+	{false, `"\u{0000041}"`, nil},
+	{false, `"\u{110000}"`, nil},
+	{false, `"\u{D800}"`, nil},
+	{false, `"\u{4G}"`, nil},
 }
 
 func TestParser(t *testing.T) {
@@ -132,6 +165,7 @@ func TestParser(t *testing.T) {
 			t.Errorf("case %d, expected an error", i)
 			continue
 		}
+		result = stripSourceInfo(result)
 		if !reflect.DeepEqual(result, c.expected) {
 			t.Errorf("case %d\ngot:\n%s\nexpected:\n%s", i, prettyPrint(result), prettyPrint(c.expected))
 		}
@@ -173,3 +207,243 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestKinds(t *testing.T) {
+	input := "true \"true\" `true` `\n| true\n` (true)"
+	expected := []Kind{Scalar, String, RawString, MultiLine, List}
+	result, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(result))
+	}
+	for i, n := range result {
+		if n.Kind != expected[i] {
+			t.Errorf("node %d, got kind %s, expected %s", i, n.Kind, expected[i])
+		}
+	}
+}
+
+var positionCases = []struct {
+	input string
+	start []Pos
+	end   []Pos
+}{
+	{"hello world", []Pos{{0, 1, 1}, {6, 1, 7}}, []Pos{{5, 1, 6}, {11, 1, 12}}},
+	{"; comment\n  (a b)\n\"c\"", []Pos{{12, 2, 3}, {18, 3, 1}}, []Pos{{17, 2, 8}, {21, 3, 4}}},
+	{"`\n  | x\n  `\r\n`raw`", []Pos{{0, 1, 1}, {13, 4, 1}}, []Pos{{11, 3, 4}, {18, 4, 6}}},
+}
+
+func TestPositions(t *testing.T) {
+	for i, c := range positionCases {
+		result, err := Parse([]byte(c.input))
+		if err != nil {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if len(result) != len(c.start) {
+			t.Errorf("case %d, expected %d nodes, got %d", i, len(c.start), len(result))
+			continue
+		}
+		for j, n := range result {
+			if n.Start != c.start[j] || n.End != c.end[j] {
+				t.Errorf("case %d, node %d: got %s-%s (%d-%d), expected %s-%s (%d-%d)", i, j,
+					n.Start, n.End, n.Start.Offset, n.End.Offset,
+					c.start[j], c.end[j], c.start[j].Offset, c.end[j].Offset)
+			}
+		}
+	}
+
+	result, err := Parse([]byte("(a\n  (b c))"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := result[0].List[1]
+	if inner.Start != (Pos{5, 2, 3}) || inner.End != (Pos{10, 2, 8}) {
+		t.Errorf("nested list: got %s-%s", inner.Start, inner.End)
+	}
+}
+
+var syntaxErrorCases = []struct {
+	input   string
+	pos     Pos
+	excerpt string
+}{
+	{"(a b)\n(c \"\\N\")", Pos{10, 2, 5}, `(c "\N")`},
+	{"abc\r\n  )", Pos{7, 2, 3}, "  )"},
+	{"(a\n(b", Pos{5, 2, 3}, "(b"},
+	{"\"abc\ndef", Pos{4, 1, 5}, `"abc`},
+	{"(a \"b\\u{DFFF}\")", Pos{5, 1, 6}, `(a "b\u{DFFF}")`},
+	{"(a \"\\u{", Pos{7, 1, 8}, `(a "\u{`},
+	{strings.Repeat("x", 100) + " \"\\q\"", Pos{102, 1, 103}, "..." + strings.Repeat("x", 28) + ` "\q"`},
+}
+
+func TestSyntaxError(t *testing.T) {
+	for i, c := range syntaxErrorCases {
+		_, err := Parse([]byte(c.input))
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("case %d, expected *SyntaxError, got: %v", i, err)
+			continue
+		}
+		if serr.Pos != c.pos {
+			t.Errorf("case %d, got position %s (%d), expected %s (%d)", i, serr.Pos, serr.Pos.Offset, c.pos, c.pos.Offset)
+		}
+		if serr.Excerpt != c.excerpt {
+			t.Errorf("case %d, got excerpt %q, expected %q", i, serr.Excerpt, c.excerpt)
+		}
+	}
+}
+
+var recoveryCases = []struct {
+	input    string
+	expected []Node
+	errors   []Pos
+}{
+	{"(a b) (c d)", expectJson(`[["a", "b"], ["c", "d"]]`), nil},
+	{"(a \"\\q\" b\n c) d", expectJson(`[["a", "c"], "d"]`), []Pos{{4, 1, 5}}},
+	{"(a \"b\n c) d", expectJson(`[["a", "c"], "d"]`), []Pos{{5, 1, 6}}},
+	{"a ) b ) c", expect("a", "b", "c"), []Pos{{2, 1, 3}, {6, 1, 7}}},
+	{"(a (b \"\\xZZ\" c) d) e", expectJson(`[["a", ["b"], "d"], "e"]`), []Pos{{7, 1, 8}}},
+	{"(a\n(b \"\\N\"\n(c", expectJson(`[["a", ["b", ["c"]]]]`), []Pos{{7, 2, 5}, {13, 3, 3}, {13, 3, 3}, {13, 3, 3}}},
+	{"\"abc\n\"def\n(x)", expectJson(`[["x"]]`), []Pos{{4, 1, 5}, {9, 2, 5}}},
+}
+
+func TestParseAll(t *testing.T) {
+	for i, c := range recoveryCases {
+		result, err := ParseAll([]byte(c.input))
+		if !reflect.DeepEqual(stripSourceInfo(result), c.expected) {
+			t.Errorf("case %d\ngot:\n%v\nexpected:\n%v", i, convertAstToJson(result), convertAstToJson(c.expected))
+		}
+		if c.errors == nil {
+			if err != nil {
+				t.Errorf("case %d, unexpected error: %s", i, err)
+			}
+			continue
+		}
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("case %d, expected ErrorList, got: %v", i, err)
+			continue
+		}
+		var positions []Pos
+		for _, e := range list {
+			positions = append(positions, e.Pos)
+		}
+		if !reflect.DeepEqual(positions, c.errors) {
+			t.Errorf("case %d, got errors:\n%v\nexpected positions: %v", i, list, c.errors)
+		}
+	}
+
+	// without recovery the behaviour is the same as for Parse
+	for i, c := range cases {
+		result, err := ParseWithOptions([]byte(c.input), ParseOptions{})
+		expected, expectedErr := Parse([]byte(c.input))
+		if !reflect.DeepEqual(result, expected) || !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("case %d, results of ParseWithOptions and Parse differ", i)
+		}
+	}
+}
+
+func TestParserNext(t *testing.T) {
+	p := newParser([]byte("`\r\n"))
+	for n, expected := range []int{'`', '\r', '\n', eof} {
+		if b := p.next(n); b != expected {
+			t.Errorf("next(%d) = %d, expected %d", n, b, expected)
+		}
+	}
+}
+
+func TestParseNoCopy(t *testing.T) {
+	for i, c := range cases {
+		result, err := ParseWithOptions([]byte(c.input), ParseOptions{NoCopy: true})
+		expected, expectedErr := Parse([]byte(c.input))
+		if !reflect.DeepEqual(result, expected) || !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("case %d, results of no-copy parsing and Parse differ", i)
+		}
+	}
+
+	data := []byte("(abc \"def\" `ghi` \"j\\x6bl\")")
+	result, err := ParseWithOptions(data, ParseOptions{NoCopy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := []bool{true, true, true, false}
+	for i, n := range result[0].List {
+		p := uintptr(unsafe.Pointer(unsafe.StringData(n.Value)))
+		begin := uintptr(unsafe.Pointer(&data[0]))
+		if s := begin <= p && p < begin+uintptr(len(data)); s != shared[i] {
+			t.Errorf("value %d (%q), expected sharing memory with the input: %v", i, n.Value, shared[i])
+		}
+	}
+}
+
+func BenchmarkParseMarathon(b *testing.B) {
+	benchmarkParse(b, ParseOptions{})
+}
+
+func BenchmarkParseMarathonNoCopy(b *testing.B) {
+	benchmarkParse(b, ParseOptions{NoCopy: true})
+}
+
+func benchmarkParse(b *testing.B, opts ParseOptions) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseWithOptions(data, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var limitCases = []struct {
+	input string
+	opts  ParseOptions
+	err   *LimitError
+}{
+	{"(a (b (c)))", ParseOptions{MaxDepth: 3}, nil},
+	{"(a (b (c)))", ParseOptions{MaxDepth: 2}, &LimitError{"MaxDepth", 2, Pos{6, 1, 7}}},
+	{"(a (b (c)))", ParseOptions{MaxDepth: 2, AllErrors: true}, &LimitError{"MaxDepth", 2, Pos{6, 1, 7}}},
+	{"(a b)\n(c)", ParseOptions{MaxNodes: 5}, nil},
+	{"(a b)\n(c)", ParseOptions{MaxNodes: 4}, &LimitError{"MaxNodes", 4, Pos{7, 2, 2}}},
+	{"(a ) b", ParseOptions{MaxNodes: 2, AllErrors: true}, &LimitError{"MaxNodes", 2, Pos{5, 1, 6}}},
+	{"abc \"d\\x65f\" `\n| ab\n| c\n`", ParseOptions{MaxValueBytes: 4}, nil},
+	{"(abc \"d\\x65fg\")", ParseOptions{MaxValueBytes: 3}, &LimitError{"MaxValueBytes", 3, Pos{5, 1, 6}}},
+	{strings.Repeat("(", 20000), ParseOptions{AllErrors: true}, &LimitError{"MaxDepth", 10000, Pos{10000, 1, 10001}}},
+	// values are checked while scanning, before the end of a value is seen
+	{"a abcd", ParseOptions{MaxValueBytes: 3}, &LimitError{"MaxValueBytes", 3, Pos{2, 1, 3}}},
+	{"\"abcd" + strings.Repeat("x", 1<<20), ParseOptions{MaxValueBytes: 3}, &LimitError{"MaxValueBytes", 3, Pos{0, 1, 1}}},
+	{"`abcd" + strings.Repeat("x", 1<<20), ParseOptions{MaxValueBytes: 3}, &LimitError{"MaxValueBytes", 3, Pos{0, 1, 1}}},
+	{"`\n| ab\n| c", ParseOptions{MaxValueBytes: 3}, &LimitError{"MaxValueBytes", 3, Pos{0, 1, 1}}},
+}
+
+func TestParseLimits(t *testing.T) {
+	for i, c := range limitCases {
+		_, err := ParseWithOptions([]byte(c.input), c.opts)
+		if c.err == nil {
+			if err != nil {
+				t.Errorf("case %d, unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(err, c.err) {
+			t.Errorf("case %d, got error: %v, expected: %v", i, err, c.err)
+		}
+	}
+
+	deep := strings.Repeat("(", 20000) + strings.Repeat(")", 20000)
+	if _, err := Parse([]byte(deep)); err == nil {
+		t.Error("expected an error for deeply nested lists")
+	}
+	if _, err := ParseCST([]byte(deep)); err == nil {
+		t.Error("expected an error for deeply nested lists")
+	}
+	if _, err := NewDecoder(strings.NewReader(deep)).Next(); err == nil {
+		t.Error("expected an error for deeply nested lists")
+	}
+}
@@ -0,0 +1,211 @@
+package sx
+
+import (
+	"bytes"
+	"strings"
+)
+
+//----------------------------------------------------------------------------
+// printer
+//----------------------------------------------------------------------------
+
+const indentUnit = "    "
+
+func isPrintable(b byte) bool {
+	return b >= 0x20 && b != 0x7F
+}
+
+// Value can be written as a scalar.
+func canBeScalar(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isPrintable(s[i]) || !isScalar(int(s[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// Value can be written as a raw string literal.
+func canBeRawString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isPrintable(s[i]) || s[i] == '`' {
+			return false
+		}
+	}
+	return true
+}
+
+// Value can be written as a multi-line string literal. Leading empty line is
+// dropped by the parser and '\r' is skipped, such values cannot be
+// represented this way. Control characters other than tabs need escaping.
+func canBeMultiLineString(s string) bool {
+	if strings.IndexByte(s, '\n') == -1 || s[0] == '\n' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; !isPrintable(b) && b != '\n' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+const hexDigits = "0123456789ABCDEF"
+
+func appendStringLiteral(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '"':
+			buf = append(buf, '\\', '"')
+		case '\\':
+			buf = append(buf, '\\', '\\')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			if !isPrintable(b) {
+				buf = append(buf, '\\', 'x', hexDigits[b>>4], hexDigits[b&0xF])
+			} else {
+				buf = append(buf, b)
+			}
+		}
+	}
+	return append(buf, '"')
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	prefix string // written before indentation of every line
+}
+
+func (p *printer) writeIndent(depth int) {
+	p.buf.WriteString(p.prefix)
+	for i := 0; i < depth; i++ {
+		p.buf.WriteString(indentUnit)
+	}
+}
+
+// Writes a scalar node. Bare scalars use the simplest literal form available,
+// other kinds keep their form when the value allows it.
+func (p *printer) writeValue(n Node, depth int) {
+	s := n.Value
+	switch {
+	case n.Kind == String:
+		p.buf.Write(appendStringLiteral(nil, s))
+	case n.Kind == RawString && canBeRawString(s):
+		p.writeRawString(s)
+	case n.Kind == MultiLine && canBeMultiLineString(s):
+		p.writeMultiLineString(s, depth)
+	case n.Kind != Scalar:
+		p.buf.Write(appendStringLiteral(nil, s))
+	case canBeScalar(s):
+		p.buf.WriteString(s)
+	case canBeMultiLineString(s):
+		p.writeMultiLineString(s, depth)
+	case canBeRawString(s) && strings.ContainsAny(s, "\"\\"):
+		p.writeRawString(s)
+	default:
+		p.buf.Write(appendStringLiteral(nil, s))
+	}
+}
+
+func (p *printer) writeRawString(s string) {
+	p.buf.WriteByte('`')
+	p.buf.WriteString(s)
+	p.buf.WriteByte('`')
+}
+
+func (p *printer) writeMultiLineString(s string, depth int) {
+	p.buf.WriteString("`\n")
+	for _, line := range strings.Split(s, "\n") {
+		p.writeIndent(depth + 1)
+		p.buf.WriteByte('|')
+		if line != "" {
+			p.buf.WriteByte(' ')
+			p.buf.WriteString(line)
+		}
+		p.buf.WriteByte('\n')
+	}
+	p.writeIndent(depth)
+	p.buf.WriteByte('`')
+}
+
+// Returns true if the list contains non-empty lists and won't fit on one
+// line.
+func isBrokenList(n Node) bool {
+	for _, elem := range n.List {
+		if len(elem.List) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists which contain only scalars and empty lists are written on a single line. Otherwise
+// the first scalar stays on the line with the opening parenthesis and every
+// other element goes on its own line. A list of a scalar and another broken
+// list shares parentheses lines with it: (name (\n...\n)).
+func (p *printer) writeNode(n Node, depth int) {
+	if n.IsScalar() {
+		p.writeValue(n, depth)
+		return
+	}
+
+	if !isBrokenList(n) {
+		p.buf.WriteByte('(')
+		for i, elem := range n.List {
+			if i != 0 {
+				p.buf.WriteByte(' ')
+			}
+			p.writeNode(elem, depth)
+		}
+		p.buf.WriteByte(')')
+		return
+	}
+
+	if len(n.List) == 2 && n.List[0].IsScalar() && isBrokenList(n.List[1]) {
+		p.buf.WriteByte('(')
+		p.writeValue(n.List[0], depth)
+		p.buf.WriteByte(' ')
+		p.writeNode(n.List[1], depth)
+		p.buf.WriteByte(')')
+		return
+	}
+
+	p.buf.WriteByte('(')
+	elems := n.List
+	if elems[0].IsScalar() {
+		p.writeValue(elems[0], depth)
+		elems = elems[1:]
+	}
+	for _, elem := range elems {
+		p.buf.WriteByte('\n')
+		p.writeIndent(depth + 1)
+		p.writeNode(elem, depth+1)
+	}
+	p.buf.WriteByte('\n')
+	p.writeIndent(depth)
+	p.buf.WriteByte(')')
+}
+
+func (p *printer) writeNodes(nodes []Node) {
+	for _, n := range nodes {
+		p.writeNode(n, 0)
+		p.buf.WriteByte('\n')
+	}
+}
+
+// Print returns sx source of the nodes, every top-level node is written on its
+// own line. The layout is the same as the one used by Marshal.
+func Print(nodes []Node) []byte {
+	var p printer
+	p.writeNodes(nodes)
+	return p.buf.Bytes()
+}
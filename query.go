@@ -0,0 +1,278 @@
+package sx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//----------------------------------------------------------------------------
+// query
+//----------------------------------------------------------------------------
+
+// QueryError is returned by Query when the query expression is invalid.
+type QueryError struct {
+	Expr   string
+	Offset int // byte offset in Expr
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query %q at offset %d: %s", e.Expr, e.Offset, e.Msg)
+}
+
+type queryStepKind int
+
+const (
+	stepKey   queryStepKind = iota // (name value...) lists, name "*" matches any key
+	stepIndex                      // [N], negative N counts from the end
+	stepAll                        // [*]
+)
+
+type queryStep struct {
+	kind  queryStepKind
+	name  string
+	index int
+	any   bool // "*" as a key
+}
+
+type queryParser struct {
+	expr string
+	i    int
+}
+
+func (q *queryParser) error(msg string) error {
+	return &QueryError{Expr: q.expr, Offset: q.i, Msg: msg}
+}
+
+// Bare keys end at the characters which have a meaning in a query or can't be
+// a part of a scalar.
+func isQueryKey(b byte) bool {
+	switch b {
+	case '.', '[', ']', '"':
+		return false
+	}
+	return isScalar(int(b))
+}
+
+func (q *queryParser) parseKey() (queryStep, error) {
+	begin := q.i
+	if q.i < len(q.expr) && q.expr[q.i] == '"' {
+		// quoted key, the same syntax as sx string literals
+		q.i++
+		for q.i < len(q.expr) && q.expr[q.i] != '"' {
+			if q.expr[q.i] == '\\' {
+				q.i++
+			}
+			q.i++
+		}
+		if q.i >= len(q.expr) {
+			q.i = begin
+			return queryStep{}, q.error("unterminated quoted key")
+		}
+		q.i++
+		nodes, err := Parse([]byte(q.expr[begin:q.i]))
+		if err != nil {
+			if se, ok := err.(*SyntaxError); ok {
+				q.i = begin + se.Pos.Offset
+				return queryStep{}, q.error(se.Msg)
+			}
+			return queryStep{}, err
+		}
+		return queryStep{kind: stepKey, name: nodes[0].Value}, nil
+	}
+	for q.i < len(q.expr) && isQueryKey(q.expr[q.i]) {
+		q.i++
+	}
+	if q.i == begin {
+		return queryStep{}, q.error("key expected")
+	}
+	name := q.expr[begin:q.i]
+	return queryStep{kind: stepKey, name: name, any: name == "*"}, nil
+}
+
+func (q *queryParser) parseIndex() (queryStep, error) {
+	q.i++ // skip '['
+	begin := q.i
+	end := strings.IndexByte(q.expr[q.i:], ']')
+	if end == -1 {
+		return queryStep{}, q.error("']' expected")
+	}
+	s := q.expr[begin : begin+end]
+	q.i = begin + end + 1
+	if s == "*" {
+		return queryStep{kind: stepAll}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		q.i = begin
+		return queryStep{}, q.error("invalid index: " + strconv.Quote(s))
+	}
+	return queryStep{kind: stepIndex, index: n}, nil
+}
+
+// Path syntax: keys separated by dots, each followed by any number of
+// indexes in square brackets. A leading dot is optional, a lone dot is the
+// whole document.
+func parseQueryPath(expr string) ([]queryStep, error) {
+	q := queryParser{expr: expr}
+	if expr == "." {
+		return nil, nil
+	}
+	var steps []queryStep
+	for q.i < len(expr) {
+		switch {
+		case expr[q.i] == '[':
+			step, err := q.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			continue
+		case expr[q.i] == '.':
+			q.i++
+		case len(steps) != 0:
+			return nil, q.error("'.' or '[' expected")
+		}
+		step, err := q.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func applyQueryStep(trees [][]Node, step queryStep) [][]Node {
+	var out [][]Node
+	for _, tree := range trees {
+		switch step.kind {
+		case stepKey:
+			for _, n := range indirectMap(tree) {
+				if n.isKeyed() && (step.any || n.List[0].Value == step.name) {
+					out = append(out, n.List[1:])
+				}
+			}
+		case stepIndex, stepAll:
+			elems := tree
+			if isTreeList(tree) {
+				elems = tree[0].List
+			}
+			if step.kind == stepAll {
+				for i := range elems {
+					out = append(out, elems[i:i+1])
+				}
+				continue
+			}
+			i := step.index
+			if i < 0 {
+				i += len(elems)
+			}
+			if i >= 0 && i < len(elems) {
+				out = append(out, elems[i:i+1])
+			}
+		}
+	}
+	return out
+}
+
+// Returns true if a node is a bare "*" scalar, quoted stars are literals.
+func isPatternWildcard(n Node) bool {
+	return n.IsScalar() && n.Kind == Scalar && n.Value == "*"
+}
+
+func matchPattern(p, n Node) bool {
+	if p.IsScalar() {
+		return isPatternWildcard(p) || (n.IsScalar() && n.Value == p.Value)
+	}
+	return !n.IsScalar() && matchPatternList(p.List, n.List)
+}
+
+// A "*" element of a list pattern matches any number of nodes. This is the
+// usual wildcard matching: on a mismatch it backtracks to the last "*" only,
+// making it consume one more node, hence it's not exponential.
+func matchPatternList(ps, ns []Node) bool {
+	pi, ni := 0, 0
+	star, mark := -1, 0
+	for ni < len(ns) {
+		switch {
+		case pi < len(ps) && isPatternWildcard(ps[pi]):
+			star, mark = pi, ni
+			pi++
+		case pi < len(ps) && matchPattern(ps[pi], ns[ni]):
+			pi++
+			ni++
+		case star != -1:
+			pi = star + 1
+			mark++
+			ni = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(ps) && isPatternWildcard(ps[pi]) {
+		pi++
+	}
+	return pi == len(ps)
+}
+
+func findPattern(out []Node, p Node, nodes []Node) []Node {
+	for _, n := range nodes {
+		if matchPattern(p, n) {
+			out = append(out, n)
+		}
+		out = findPattern(out, p, n.List)
+	}
+	return out
+}
+
+// Query returns nodes selected by the expression. There are two forms of
+// expressions.
+//
+// A path follows the (key value...) convention used by Unmarshal, e.g.
+// "container.docker.portMappings[0].hostPort". A key selects values of all
+// lists starting with it, "*" selects values of all such lists. An index
+// selects an element of a value, negative indexes count from the end and [*]
+// selects every element. Keys with special characters can be quoted:
+// labels."app.kubernetes.io/name". Values of all matches are concatenated.
+//
+// A pattern is an sx list, e.g. "(ports *)", it selects all lists at any
+// depth which match it. Scalars in a pattern match equal scalars, a bare "*"
+// matches any number of list elements or any node elsewhere.
+//
+// Nothing matched is not an error, an empty result is returned.
+func Query(nodes []Node, expr string) ([]Node, error) {
+	trimmed := strings.TrimSpace(expr)
+	if strings.HasPrefix(trimmed, "(") {
+		p, err := Parse([]byte(trimmed))
+		if err != nil {
+			if se, ok := err.(*SyntaxError); ok {
+				offset := strings.Index(expr, trimmed) + se.Pos.Offset
+				return nil, &QueryError{Expr: expr, Offset: offset, Msg: se.Msg}
+			}
+			return nil, err
+		}
+		if len(p) != 1 {
+			return nil, &QueryError{Expr: expr, Offset: 0, Msg: "pattern must be a single list"}
+		}
+		return findPattern(nil, p[0], nodes), nil
+	}
+
+	steps, err := parseQueryPath(trimmed)
+	if err != nil {
+		if qe, ok := err.(*QueryError); ok {
+			qe.Offset += strings.Index(expr, trimmed)
+			qe.Expr = expr
+		}
+		return nil, err
+	}
+	trees := [][]Node{nodes}
+	for _, step := range steps {
+		trees = applyQueryStep(trees, step)
+	}
+	var out []Node
+	for _, tree := range trees {
+		out = append(out, tree...)
+	}
+	return out, nil
+}
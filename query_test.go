@@ -0,0 +1,98 @@
+package sx
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var queryCases = []struct {
+	input    string
+	query    string
+	expected string // printed result
+	valid    bool
+}{
+	{"", "container.docker.portMappings[0].hostPort", "0\n", true},
+	{"", "container.docker.portMappings[*].protocol", "tcp\nudp\n", true},
+	{"", "container.docker.portMappings[-1].containerPort", "161\n", true},
+	{"", "container.docker.image", "group/image\n", true},
+	{"", ".ports", "8080\n9000\n", true},
+	{"", "ports[1]", "9000\n", true},
+	{"", "ports[2]", "", true},
+	{"", "args[-1]", "\"env && sleep 300\"\n", true},
+	{"", "labels", "(environment staging)\n", true},
+	{"", "(ports *)", "(ports 8080 9000)\n", true},
+	{"", "(key *)", "(key a-docker-option)\n(key b-docker-option)\n", true},
+	{"", "((key *) (value xxx))", "(\n    (key a-docker-option)\n    (value xxx)\n)\n", true},
+	{"", "(hostPort 0)", "(hostPort 0)\n(hostPort 0)\n", true},
+	{"", "nothing.here", "", true},
+	{"(a (1 2 3)) (b 4)", "a[0]", "1\n", true},
+	{"(a (1 2 3)) (b 4)", "*", "(1 2 3)\n4\n", true},
+	{"(a (1 2 3)) (b 4)", ".", "(a\n    (1 2 3)\n)\n(b 4)\n", true},
+	{"(a 1 2) (b (3 4)) (c)", "*[0]", "1\n3\n", true},
+	{"(a (1 2 3)) (b 4)", "[1]", "(b 4)\n", true},
+	{"((x.y 1) (x 2))", `"x.y"`, "1\n", true},
+	{`("*" 1) (b 2)`, `("*" *)`, "(\"*\" 1)\n", true},
+	{"(a (1 (2 3)))", "(* 3)", "(2 3)\n", true},
+	{"(a b c) (a c) (b a c)", "(a * c)", "(a b c)\n(a c)\n", true},
+	{"(a b c b) (a b)", "(* b * *)", "(a b c b)\n(a b)\n", true},
+	{"(a (b c) d) (a (b d) d)", "(* (b *) d)", "(a\n    (b c)\n    d\n)\n(a\n    (b d)\n    d\n)\n", true},
+	{"(a 1)", "a.", "", false},
+	{"(a 1)", "a[x]", "", false},
+	{"(a 1)", "a[0", "", false},
+	{"(a 1)", "a b", "", false},
+	{"(a 1)", `"a`, "", false},
+	{"(a 1)", "(a", "", false},
+	{"(a 1)", "(a) (b)", "", false},
+}
+
+func TestQuery(t *testing.T) {
+	marathon, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range queryCases {
+		input := []byte(c.input)
+		if c.input == "" {
+			input = marathon
+		}
+		nodes, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := Query(nodes, c.query)
+		if err != nil && c.valid {
+			t.Errorf("case %d, unexpected error: %s", i, err)
+			continue
+		}
+		if err == nil && !c.valid {
+			t.Errorf("case %d, error expected", i)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*QueryError); !ok {
+				t.Errorf("case %d, expected a query error, got: %v", i, err)
+			}
+			continue
+		}
+		if out := string(Print(result)); out != c.expected {
+			t.Errorf("case %d, got:\n%s\nexpected:\n%s", i, out, c.expected)
+		}
+	}
+}
+
+func TestQueryPatternWildcards(t *testing.T) {
+	// Retrying every suffix for every "*" is exponential, this takes ages
+	// then. Nothing matches, because there is no b.
+	nodes, err := Parse([]byte("(" + strings.Repeat("a ", 40) + ")"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Query(nodes, "(* * * * * * * * * * * * b)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 0 {
+		t.Errorf("got:\n%s", Print(result))
+	}
+}
@@ -0,0 +1,132 @@
+package sx
+
+import (
+	"fmt"
+)
+
+//----------------------------------------------------------------------------
+// scanner
+//----------------------------------------------------------------------------
+
+// TokenKind is a lexical element of sx source.
+type TokenKind int
+
+const (
+	TokenEOF        TokenKind = iota // end of input
+	TokenLParen                      // (
+	TokenRParen                      // )
+	TokenScalar                      // hello
+	TokenString                      // "hello"
+	TokenRawString                   // `hello`
+	TokenMultiLine                   // `\n| hello\n`
+	TokenComment                     // ; hello
+	TokenWhitespace                  // spaces, tabs and new lines
+)
+
+var tokenKindNames = [...]string{
+	TokenEOF:        "eof",
+	TokenLParen:     "(",
+	TokenRParen:     ")",
+	TokenScalar:     "scalar",
+	TokenString:     "string",
+	TokenRawString:  "raw string",
+	TokenMultiLine:  "multi-line string",
+	TokenComment:    "comment",
+	TokenWhitespace: "whitespace",
+}
+
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenKindNames) {
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+	return tokenKindNames[k]
+}
+
+// Token is a lexical element with its byte range in the source, which is
+// data[Start.Offset:End.Offset].
+type Token struct {
+	Kind  TokenKind
+	Value string // value of a scalar or a string, empty if it's invalid
+	Start Pos
+	End   Pos
+}
+
+// Scanner splits sx source into tokens. Tokens cover the whole input, there
+// are no gaps between them. Syntax errors don't stop the scanner: an invalid
+// literal becomes a token of its kind, covering the part which was consumed,
+// and the error is recorded. Scanner doesn't check that parentheses are
+// balanced, that's the parser's job.
+type Scanner struct {
+	p *parser
+}
+
+func NewScanner(data []byte) *Scanner {
+	p := newParser(data)
+	p.recover = true
+	return &Scanner{p: p}
+}
+
+// Errors returns syntax errors found so far.
+func (s *Scanner) Errors() ErrorList {
+	return s.p.errors
+}
+
+// Skips the rest of an invalid string literal: up to and including the
+// closing '"', or up to the end of the line.
+func (s *Scanner) skipString() {
+	p := s.p
+	for b := p.current(); b != eof && b != '\n'; b = p.advance() {
+		switch b {
+		case '\\':
+			if p.next(1) != '\n' {
+				p.advance()
+			}
+		case '"':
+			p.advance()
+			return
+		}
+	}
+}
+
+// Next returns the next token, TokenEOF at the end of input.
+func (s *Scanner) Next() Token {
+	p := s.p
+	start := p.pos()
+	tok := Token{Start: start}
+	b := p.current()
+	switch {
+	case b == eof:
+		tok.Kind = TokenEOF
+	case isSpace(b):
+		tok.Kind = TokenWhitespace
+		p.skipToNonSpace()
+	case b == ';':
+		tok.Kind = TokenComment
+		p.skipComment()
+	case b == '(':
+		tok.Kind = TokenLParen
+		p.advance()
+	case b == ')':
+		tok.Kind = TokenRParen
+		p.advance()
+	default:
+		switch {
+		case b == '"':
+			tok.Kind = TokenString
+		case p.matches("`\n") || p.matches("`\r\n"):
+			tok.Kind = TokenMultiLine
+		case b == '`':
+			tok.Kind = TokenRawString
+		default:
+			tok.Kind = TokenScalar
+		}
+		node, ok := p.parseValue()
+		if ok {
+			tok.Value = node.Value
+		} else if tok.Kind == TokenString {
+			s.skipString()
+		}
+	}
+	tok.End = p.pos()
+	return tok
+}
@@ -0,0 +1,93 @@
+package sx
+
+import (
+	"reflect"
+	"testing"
+)
+
+type scannedToken struct {
+	kind  TokenKind
+	text  string
+	value string
+}
+
+var scannerCases = []struct {
+	input    string
+	expected []scannedToken
+	errors   []Pos
+}{
+	{"(a \"b\\n\") ; c\n", []scannedToken{
+		{TokenLParen, "(", ""},
+		{TokenScalar, "a", "a"},
+		{TokenWhitespace, " ", ""},
+		{TokenString, `"b\n"`, "b\n"},
+		{TokenRParen, ")", ""},
+		{TokenWhitespace, " ", ""},
+		{TokenComment, "; c", ""},
+		{TokenWhitespace, "\n", ""},
+	}, nil},
+	{"`raw` `\n  | x\n  `)", []scannedToken{
+		{TokenRawString, "`raw`", "raw"},
+		{TokenWhitespace, " ", ""},
+		{TokenMultiLine, "`\n  | x\n  `", "x"},
+		{TokenRParen, ")", ""},
+	}, nil},
+	{"(a \"b\\q c\" d)\n\"e\n`f", []scannedToken{
+		{TokenLParen, "(", ""},
+		{TokenScalar, "a", "a"},
+		{TokenWhitespace, " ", ""},
+		{TokenString, `"b\q c"`, ""},
+		{TokenWhitespace, " ", ""},
+		{TokenScalar, "d", "d"},
+		{TokenRParen, ")", ""},
+		{TokenWhitespace, "\n", ""},
+		{TokenString, `"e`, ""},
+		{TokenWhitespace, "\n", ""},
+		{TokenRawString, "`f", ""},
+	}, []Pos{{5, 1, 6}, {16, 2, 3}, {19, 3, 3}}},
+	{"\"\\\"\" \"\\", []scannedToken{
+		{TokenString, `"\""`, `"`},
+		{TokenWhitespace, " ", ""},
+		{TokenString, `"\`, ""},
+	}, []Pos{{7, 1, 8}}},
+}
+
+func TestScanner(t *testing.T) {
+	for i, c := range scannerCases {
+		s := NewScanner([]byte(c.input))
+		var tokens []scannedToken
+		for {
+			tok := s.Next()
+			if tok.Kind == TokenEOF {
+				if tok.Start.Offset != len(c.input) {
+					t.Errorf("case %d, unexpected eof at %s", i, tok.Start)
+				}
+				break
+			}
+			text := c.input[tok.Start.Offset:tok.End.Offset]
+			tokens = append(tokens, scannedToken{tok.Kind, text, tok.Value})
+		}
+		if !reflect.DeepEqual(tokens, c.expected) {
+			t.Errorf("case %d\ngot:\n%+v\nexpected:\n%+v", i, tokens, c.expected)
+		}
+		var positions []Pos
+		for _, e := range s.Errors() {
+			positions = append(positions, e.Pos)
+		}
+		if !reflect.DeepEqual(positions, c.errors) {
+			t.Errorf("case %d, got errors:\n%v\nexpected positions: %v", i, s.Errors(), c.errors)
+		}
+	}
+
+	// tokens cover the whole input
+	for i, c := range cases {
+		s := NewScanner([]byte(c.input))
+		text := ""
+		for tok := s.Next(); tok.Kind != TokenEOF; tok = s.Next() {
+			text += c.input[tok.Start.Offset:tok.End.Offset]
+		}
+		if text != c.input {
+			t.Errorf("case %d, got %q, expected %q", i, text, c.input)
+		}
+	}
+}
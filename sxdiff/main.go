@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/nsf/sx"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+var sxOutput = flag.Bool("sx", false, "write changes in sx form: (changed PATH (old VALUE...) (new VALUE...))")
+
+func parseFile(filename string) []sx.Node {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatalf("error reading file: %s", err)
+	}
+	nodes, err := sx.Parse(data)
+	if err != nil {
+		log.Fatalf("error parsing %s: %s", filename, err)
+	}
+	return nodes
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <old sx file> <new sx file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "exit status is 0 if the documents are equal, 1 if they differ\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	a := parseFile(flag.Arg(0))
+	b := parseFile(flag.Arg(1))
+
+	changes := sx.Diff(a, b)
+	if *sxOutput {
+		nodes := make([]sx.Node, len(changes))
+		for i, c := range changes {
+			nodes[i] = c.Node()
+		}
+		os.Stdout.Write(sx.Print(nodes))
+	} else {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+	if len(changes) != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/nsf/sx"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+var (
+	raw    = flag.Bool("r", false, "write scalars as raw values instead of sx literals")
+	status = flag.Bool("e", false, "exit with status 1 if nothing matched")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <query> [sx file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "reads standard input if no file is given, e.g.:\n")
+		fmt.Fprintf(os.Stderr, "    %s -r container.docker.portMappings[0].hostPort app.sx\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "    %s '(ports *)' app.sx\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var data []byte
+	var err error
+	if flag.NArg() == 2 {
+		data, err = ioutil.ReadFile(flag.Arg(1))
+	} else {
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		log.Fatalf("error reading input: %s", err)
+	}
+	nodes, err := sx.Parse(data)
+	if err != nil {
+		log.Fatalf("error parsing sx: %s", err)
+	}
+
+	result, err := sx.Query(nodes, flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, n := range result {
+		if *raw && n.IsScalar() {
+			fmt.Println(n.Value)
+			continue
+		}
+		os.Stdout.Write(sx.Print([]sx.Node{n}))
+	}
+	if *status && len(result) == 0 {
+		os.Exit(1)
+	}
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/nsf/sx"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 )
 
+var (
+	typed  = flag.Bool("typed", false, "convert (key value...) lists to objects and scalars to numbers, booleans and null where they look like such")
+	schema = flag.String("schema", "", "JSON `file` of the same shape as the output, e.g. a sample, implies -typed")
+)
+
 func astToJson(vs []sx.Node) []interface{} {
 	out := []interface{}{}
 	for _, v := range vs {
@@ -22,18 +29,48 @@ func astToJson(vs []sx.Node) []interface{} {
 }
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("usage: %s <sx file>\n", os.Args[0])
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <sx file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	data, err := ioutil.ReadFile(os.Args[1])
+	f, err := os.Open(flag.Arg(0))
 	if err != nil {
 		log.Fatalf("error reading file: %s", err)
 	}
+	defer f.Close()
 
-	ast, err := sx.Parse(data)
-	if err != nil {
-		log.Fatalf("error parsing sx file: %s", err)
+	var ast []sx.Node
+	d := sx.NewDecoder(f)
+	for {
+		node, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error parsing sx file: %s", err)
+		}
+		ast = append(ast, node)
+	}
+
+	if *typed || *schema != "" {
+		var s []byte
+		if *schema != "" {
+			s, err = ioutil.ReadFile(*schema)
+			if err != nil {
+				log.Fatalf("error reading schema file: %s", err)
+			}
+		}
+		js, err := sx.ToJSON(ast, s)
+		if err != nil {
+			log.Fatalf("error converting sx to json: %s", err)
+		}
+		os.Stdout.Write(js)
+		return
 	}
 
 	js, err := json.MarshalIndent(astToJson(ast), "", "    ")
@@ -1,15 +1,86 @@
 package sx
 
 import (
+	"bytes"
+	"encoding"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
 type Unmarshaler interface {
 	UnmarshalSX(tree []Node) error
 }
 
+// UnmarshalError describes a failure to unmarshal a tree into a Go value.
+type UnmarshalError struct {
+	Path  string       // path to the value, e.g. container.docker.portMappings[1].hostPort
+	Type  reflect.Type // type of the value
+	Value string       // value of the offending node, if it's a scalar
+	Pos   Pos          // position of the offending node, zero if unknown
+	Err   error
+}
+
+func (e *UnmarshalError) Error() string {
+	var buf bytes.Buffer
+	if e.Pos.Line != 0 {
+		fmt.Fprintf(&buf, "%s: ", e.Pos)
+	}
+	buf.WriteString("cannot unmarshal ")
+	if e.Value != "" {
+		fmt.Fprintf(&buf, "%q ", e.Value)
+	}
+	buf.WriteString("into ")
+	if e.Path != "" {
+		fmt.Fprintf(&buf, "%s of type ", e.Path)
+	} else {
+		buf.WriteString("value of type ")
+	}
+	fmt.Fprintf(&buf, "%s: %s", e.Type, e.Err)
+	return buf.String()
+}
+
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// Creates an error about 'tree' which cannot be stored in 'v'. The path is
+// filled by callers as the error goes up.
+func unmarshalError(tree []Node, v reflect.Value, err error) error {
+	if e, ok := err.(*UnmarshalError); ok {
+		return e
+	}
+	e := &UnmarshalError{Type: v.Type(), Err: err}
+	if len(tree) != 0 {
+		e.Pos = tree[0].Start
+	}
+	if isTreeScalar(tree) {
+		e.Value = tree[0].Value
+	}
+	return e
+}
+
+// Prepends a path element to the path of an *UnmarshalError.
+func prependPath(err error, elem string) error {
+	if e, ok := err.(*UnmarshalError); ok {
+		switch {
+		case e.Path == "":
+			e.Path = elem
+		case e.Path[0] == '[':
+			e.Path = elem + e.Path
+		default:
+			e.Path = elem + "." + e.Path
+		}
+	}
+	return err
+}
+
 // returns true if tree's length is 1 and the only node is a scalar
 func isTreeScalar(tree []Node) bool {
 	if len(tree) != 1 {
@@ -40,22 +111,158 @@ func indirectMap(tree []Node) []Node {
 	return tree
 }
 
+type unmarshalerKind int
+
+const (
+	noUnmarshaler unmarshalerKind = iota
+	sxUnmarshaler
+	sxPtrUnmarshaler // *T implements Unmarshaler
+	textUnmarshaler
+	textPtrUnmarshaler // *T implements encoding.TextUnmarshaler
+)
+
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	unmarshalerKinds    sync.Map // map[reflect.Type]unmarshalerKind
+)
+
+// Unmarshaler is preferred over encoding.TextUnmarshaler, T is preferred
+// over *T.
+func cachedUnmarshalerKind(t reflect.Type) unmarshalerKind {
+	if k, ok := unmarshalerKinds.Load(t); ok {
+		return k.(unmarshalerKind)
+	}
+	k := noUnmarshaler
+	pt := reflect.PtrTo(t)
+	switch {
+	case t.Kind() == reflect.Interface:
+		// the value is replaced, its methods don't matter
+	case t.Implements(unmarshalerType):
+		k = sxUnmarshaler
+	case pt.Implements(unmarshalerType):
+		k = sxPtrUnmarshaler
+	case t.Implements(textUnmarshalerType):
+		k = textUnmarshaler
+	case pt.Implements(textUnmarshalerType):
+		k = textPtrUnmarshaler
+	}
+	unmarshalerKinds.Store(t, k)
+	return k
+}
+
+// Tries Unmarshaler first, then encoding.TextUnmarshaler, which accepts a
+// scalar only.
 func tryUnmarshaler(tree []Node, v reflect.Value) (bool, error) {
-	u, ok := v.Interface().(Unmarshaler)
-	if !ok {
-		// T doesn't work, try *T as well
-		if v.Kind() != reflect.Ptr && v.CanAddr() {
-			u, ok = v.Addr().Interface().(Unmarshaler)
+	k := cachedUnmarshalerKind(v.Type())
+	u := v
+	if k == sxPtrUnmarshaler || k == textPtrUnmarshaler {
+		if !v.CanAddr() {
+			return false, nil
 		}
+		u = v.Addr()
 	}
 
-	if ok {
-		return true, u.UnmarshalSX(tree)
+	var err error
+	switch k {
+	case noUnmarshaler:
+		return false, nil
+	case sxUnmarshaler, sxPtrUnmarshaler:
+		err = u.Interface().(Unmarshaler).UnmarshalSX(tree)
+	case textUnmarshaler, textPtrUnmarshaler:
+		if !isTreeScalar(tree) {
+			return true, unmarshalError(tree, v, errors.New("scalar node expected"))
+		}
+		err = u.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(tree[0].Value))
 	}
-	return false, nil
+	if err != nil {
+		return true, unmarshalError(tree, v, err)
+	}
+	return true, nil
+}
+
+// Decoding options, the zero value is the default behaviour of Unmarshal.
+type decodeState struct {
+	disallowUnknownFields   bool
+	disallowDuplicateFields bool
+	disallowExtraItems      bool
+	useMaps                 bool
 }
 
-func unmarshalValue(tree []Node, v reflect.Value) error {
+// Returns true if the tree looks like a map: a non-empty sequence of
+// (key value...) lists with scalar keys.
+func isTreeMap(tree []Node) bool {
+	if len(tree) == 0 {
+		return false
+	}
+	for _, node := range tree {
+		if len(node.List) < 2 || !node.List[0].IsScalar() {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a free-form representation of a tree: a scalar becomes a string, a
+// list becomes []interface{} and optionally a list of (key value...) lists
+// becomes map[string]interface{}.
+func (d *decodeState) interfaceValue(tree []Node) (interface{}, error) {
+	if isTreeScalar(tree) {
+		return tree[0].Value, nil
+	}
+	if d.useMaps {
+		if t := indirectMap(tree); isTreeMap(t) {
+			return d.mapValue(t)
+		}
+	}
+	if isTreeList(tree) {
+		// same indirection as for slices:
+		//   (a (1 2 3)) vs (a 1 2 3)
+		tree = tree[0].List
+	}
+	return d.listValue(tree)
+}
+
+// Unlike trees, list elements are taken as is: (a (x 1)) is a list of a
+// scalar and a list, even if a list of lists looks like a map.
+func (d *decodeState) listValue(nodes []Node) (interface{}, error) {
+	out := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		var val interface{}
+		var err error
+		switch {
+		case node.IsScalar():
+			val = node.Value
+		case d.useMaps && isTreeMap(node.List):
+			val, err = d.mapValue(node.List)
+		default:
+			val, err = d.listValue(node.List)
+		}
+		if err != nil {
+			return nil, prependPath(err, "["+strconv.Itoa(i)+"]")
+		}
+		out[i] = val
+	}
+	return out, nil
+}
+
+func (d *decodeState) mapValue(nodes []Node) (interface{}, error) {
+	m := make(map[string]interface{}, len(nodes))
+	for _, node := range nodes {
+		key := node.List[0].Value
+		if _, ok := m[key]; ok && d.disallowDuplicateFields {
+			return nil, unmarshalError(node.List[:1], reflect.ValueOf(m), errors.New("duplicate map key"))
+		}
+		val, err := d.interfaceValue(node.List[1:])
+		if err != nil {
+			return nil, prependPath(err, key)
+		}
+		m[key] = val
+	}
+	return m, nil
+}
+
+func (d *decodeState) unmarshalValue(tree []Node, v reflect.Value) error {
 	t := v.Type()
 
 	// one level of indirection is supported
@@ -74,40 +281,48 @@ func unmarshalValue(tree []Node, v reflect.Value) error {
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if !isTreeScalar(tree) {
-			return fmt.Errorf("scalar node expected")
+			return unmarshalError(tree, v, errors.New("scalar node expected"))
+		}
+		if t == durationType {
+			dur, err := time.ParseDuration(tree[0].Value)
+			if err != nil {
+				return unmarshalError(tree, v, errors.New("node is not a duration"))
+			}
+			v.SetInt(int64(dur))
+			break
 		}
 		num, err := strconv.ParseInt(tree[0].Value, 10, 64)
 		if err != nil {
-			return fmt.Errorf("node is not an integer")
+			return unmarshalError(tree, v, errors.New("node is not an integer"))
 		}
 		if v.OverflowInt(num) {
-			return fmt.Errorf("integer overflow")
+			return unmarshalError(tree, v, errors.New("integer overflow"))
 		}
 		v.SetInt(num)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		if !isTreeScalar(tree) {
-			return fmt.Errorf("scalar node expected")
+			return unmarshalError(tree, v, errors.New("scalar node expected"))
 		}
 		num, err := strconv.ParseUint(tree[0].Value, 10, 64)
 		if err != nil {
-			return fmt.Errorf("node is not an unsigned integer")
+			return unmarshalError(tree, v, errors.New("node is not an unsigned integer"))
 		}
 		if v.OverflowUint(num) {
-			return fmt.Errorf("unsigned integer overflow")
+			return unmarshalError(tree, v, errors.New("unsigned integer overflow"))
 		}
 		v.SetUint(num)
 	case reflect.Float32, reflect.Float64:
 		if !isTreeScalar(tree) {
-			return fmt.Errorf("scalar node expected")
+			return unmarshalError(tree, v, errors.New("scalar node expected"))
 		}
 		num, err := strconv.ParseFloat(tree[0].Value, 64)
 		if err != nil {
-			return fmt.Errorf("node is not a floating point number")
+			return unmarshalError(tree, v, errors.New("node is not a floating point number"))
 		}
 		v.SetFloat(num)
 	case reflect.Bool:
 		if !isTreeScalar(tree) {
-			return fmt.Errorf("scalar node expected")
+			return unmarshalError(tree, v, errors.New("scalar node expected"))
 		}
 		switch tree[0].Value {
 		case "true":
@@ -115,11 +330,11 @@ func unmarshalValue(tree []Node, v reflect.Value) error {
 		case "false":
 			v.SetBool(false)
 		default:
-			return fmt.Errorf("invalid boolean value, use true|false")
+			return unmarshalError(tree, v, errors.New("invalid boolean value, use true|false"))
 		}
 	case reflect.String:
 		if !isTreeScalar(tree) {
-			return fmt.Errorf("scalar node expected")
+			return unmarshalError(tree, v, errors.New("scalar node expected"))
 		}
 		v.SetString(tree[0].Value)
 	case reflect.Array, reflect.Slice:
@@ -135,14 +350,15 @@ func unmarshalValue(tree []Node, v reflect.Value) error {
 			// slice. Nothing stops you from implementing Unmarshaler interface
 			// though.
 			v.Set(reflect.MakeSlice(t, len(tree), len(tree)))
-		} else {
-			if len(tree) > v.Len() {
-				tree = tree[:v.Len()]
+		} else if len(tree) > v.Len() {
+			if d.disallowExtraItems {
+				return unmarshalError(tree[v.Len():v.Len()+1], v, fmt.Errorf("too many items, expected at most %d", v.Len()))
 			}
+			tree = tree[:v.Len()]
 		}
 		for i := range tree {
-			if err := unmarshalValue(tree[i:i+1], v.Index(i)); err != nil {
-				return err
+			if err := d.unmarshalValue(tree[i:i+1], v.Index(i)); err != nil {
+				return prependPath(err, "["+strconv.Itoa(i)+"]")
 			}
 		}
 
@@ -157,64 +373,133 @@ func unmarshalValue(tree []Node, v reflect.Value) error {
 		v.Set(reflect.MakeMap(t))
 		keyv := reflect.New(t.Key()).Elem()
 		valv := reflect.New(t.Elem()).Elem()
+		var seen map[string]bool
+		if d.disallowDuplicateFields {
+			seen = map[string]bool{}
+		}
 		for _, node := range indirectMap(tree) {
 			if node.IsScalar() {
-				return fmt.Errorf("map element must be represented via (key value...) list")
+				return unmarshalError([]Node{node}, v, errors.New("map element must be represented via (key value...) list"))
 			}
 			list := node.List
 			if len(list) < 2 {
-				return fmt.Errorf("valid map element list must contain at least two items")
+				return unmarshalError([]Node{node}, v, errors.New("valid map element list must contain at least two items"))
+			}
+			if err := d.unmarshalValue(list[:1], keyv); err != nil {
+				return err
 			}
-			if err := unmarshalValue(list[:1], keyv); err != nil {
-				return fmt.Errorf("key unmarshaling failure: %s", err)
+			if seen != nil && list[0].IsScalar() {
+				if seen[list[0].Value] {
+					return unmarshalError(list[:1], v, errors.New("duplicate map key"))
+				}
+				seen[list[0].Value] = true
 			}
-			if err := unmarshalValue(list[1:], valv); err != nil {
-				return fmt.Errorf("value unmarshaling failure: %s", err)
+			if err := d.unmarshalValue(list[1:], valv); err != nil {
+				return prependPath(err, list[0].Value)
 			}
 			v.SetMapIndex(keyv, valv)
 		}
 	case reflect.Struct:
+		plan := cachedStructPlan(t)
+		fields := plan.fields
+		var seen []bool
+		if plan.missing || d.disallowDuplicateFields {
+			seen = make([]bool, len(fields))
+		}
 		for _, node := range indirectMap(tree) {
 			if node.IsScalar() {
-				return fmt.Errorf("struct field must be represented via (name value...) list")
+				return unmarshalError([]Node{node}, v, errors.New("struct field must be represented via (name value...) list"))
 			}
 			list := node.List
 			if len(list) < 2 {
-				return fmt.Errorf("valid struct field list must contain at least two items")
+				return unmarshalError([]Node{node}, v, errors.New("valid struct field list must contain at least two items"))
 			}
 			if !list[0].IsScalar() {
-				return fmt.Errorf("first element of the struct field list must be scalar")
+				return unmarshalError(list[:1], v, errors.New("first element of the struct field list must be scalar"))
 			}
 			name := list[0].Value
-			var f reflect.StructField
-			var ok bool
-			for i, n := 0, t.NumField(); i < n; i++ {
-				f = t.Field(i)
-				tag := f.Tag.Get("sx")
-				if tag == "-" {
-					continue
-				}
-				if f.Anonymous {
-					continue
-				}
-				if ok = tag == name; ok {
-					break
-				}
-				if ok = f.Name == name; ok {
-					break
+			i, ok := plan.lookup(name)
+			if !ok {
+				if d.disallowUnknownFields {
+					return unmarshalError(list[:1], v, errors.New("unknown field"))
 				}
+				continue
 			}
-			if ok {
-				if f.PkgPath != "" {
-					return fmt.Errorf("writing to unexported field")
-				}
-				if err := unmarshalValue(list[1:], v.FieldByIndex(f.Index)); err != nil {
-					return err
+			if seen != nil {
+				if seen[i] && d.disallowDuplicateFields {
+					return unmarshalError(list[:1], v, errors.New("duplicate field"))
 				}
+				seen[i] = true
+			}
+			f := fields[i]
+			if f.unexported {
+				return prependPath(unmarshalError(list[:1], reflect.Zero(f.typ), errors.New("writing to unexported field")), name)
+			}
+			fv, err := fieldByIndex(v, f.index)
+			if err != nil {
+				return prependPath(unmarshalError(list[:1], reflect.Zero(f.typ), err), name)
 			}
+			if err := d.unmarshalValue(list[1:], fv); err != nil {
+				return prependPath(err, name)
+			}
+		}
+		if plan.missing {
+			if err := d.fillMissingFields(tree, v, fields, seen); err != nil {
+				return err
+			}
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return unmarshalError(tree, v, errors.New("unsupported type"))
+		}
+		val, err := d.interfaceValue(tree)
+		if err != nil {
+			return err
 		}
+		v.Set(reflect.ValueOf(val))
 	default:
-		return fmt.Errorf("unsupported type")
+		return unmarshalError(tree, v, errors.New("unsupported type"))
+	}
+	return nil
+}
+
+// Sets default values of the fields which were not seen in the input and
+// reports missing required fields, all of them at once.
+func (d *decodeState) fillMissingFields(tree []Node, v reflect.Value, fields []field, seen []bool) error {
+	var missing []string
+	for i, f := range fields {
+		if seen[i] || f.unexported {
+			continue
+		}
+		if f.required {
+			missing = append(missing, f.name)
+			continue
+		}
+		if !f.hasDefault {
+			continue
+		}
+		fv, err := fieldByIndex(v, f.index)
+		if err != nil {
+			return prependPath(unmarshalError(nil, reflect.Zero(f.typ), err), f.name)
+		}
+		err = f.defErr
+		if err == nil {
+			err = d.unmarshalValue(f.defTree, fv)
+		}
+		if err != nil {
+			if e, ok := err.(*UnmarshalError); ok {
+				err = e.Err
+			}
+			return &UnmarshalError{
+				Path:  f.name,
+				Type:  f.typ,
+				Value: f.def,
+				Err:   fmt.Errorf("invalid default value: %s", err),
+			}
+		}
+	}
+	if len(missing) != 0 {
+		return unmarshalError(tree, v, fmt.Errorf("missing required fields: %s", strings.Join(missing, ", ")))
 	}
 	return nil
 }
@@ -222,7 +507,14 @@ func unmarshalValue(tree []Node, v reflect.Value) error {
 // Parse and unmarshal sx data into a value pointed to by 'out', hence 'out'
 // must be a pointer.
 func Unmarshal(data []byte, out interface{}) error {
-	tree, err := Parse(data)
+	return UnmarshalWithOptions(data, out, ParseOptions{})
+}
+
+// UnmarshalWithOptions is like Unmarshal, but parses the data with the given
+// options, e.g. limits for untrusted input. With AllErrors set nothing is
+// unmarshaled if there are syntax errors, the error is an ErrorList then.
+func UnmarshalWithOptions(data []byte, out interface{}, opts ParseOptions) error {
+	tree, err := ParseWithOptions(data, opts)
 	if err != nil {
 		return err
 	}
@@ -233,5 +525,6 @@ func Unmarshal(data []byte, out interface{}) error {
 		panic("sx.Unmarshal expects a non-nil pointer as 'out' argument")
 	}
 
-	return unmarshalValue(tree, v.Elem())
+	var d decodeState
+	return d.unmarshalValue(tree, v.Elem())
 }
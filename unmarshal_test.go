@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type SChan struct {
@@ -103,6 +107,107 @@ type S11 struct {
 	x byte
 }
 
+type S12 struct {
+	Labels interface{}
+}
+
+type S13 struct {
+	Err error
+}
+
+type STextual struct {
+	IP     net.IP
+	Prefix netip.Prefix
+	Big    *big.Int
+	Hosts  map[netip.Addr]string
+}
+
+type SLimits struct {
+	Timeout  time.Duration
+	Deadline time.Time
+	Memory   ByteSize
+	Disk     *ByteSize
+}
+
+type SDeployment struct {
+	ID      string            `sx:"id,required"`
+	Cmd     string            `sx:"cmd,required"`
+	Port    int               `sx:"port,default=8080"`
+	Args    []string          `sx:"args,omitempty,default=(-v \"a, b\")"`
+	Timeout time.Duration     `sx:"timeout,default=30s"`
+	Labels  map[string]string `sx:"labels,omitempty"`
+}
+
+type SBadDefault struct {
+	Port int `sx:"port,default=http"`
+}
+
+type SBadDefaultSyntax struct {
+	Name string `sx:"name,default=(a"`
+}
+
+type CommonOptions struct {
+	Name    string `sx:"name"`
+	Verbose bool   `sx:"verbose"`
+}
+
+type LogOptions struct {
+	Level string `sx:"level"`
+	Name  string `sx:"name"`
+}
+
+type secretOptions struct {
+	Token string `sx:"token"`
+}
+
+type SEmbedded struct {
+	CommonOptions
+	*LogOptions
+	secretOptions
+	Port int `sx:"port"`
+}
+
+type SEmbeddedShadow struct {
+	CommonOptions
+	Verbose string `sx:"verbose"`
+}
+
+type SEmbeddedTagged struct {
+	CommonOptions `sx:"common"`
+}
+
+type SEmbeddedConflict struct {
+	A struct{ X int }
+	B struct{ X int }
+}
+
+type EmbeddedX struct {
+	X int
+}
+
+type embeddedXA struct {
+	EmbeddedX
+}
+
+type embeddedXB struct {
+	EmbeddedX
+}
+
+// X is promoted from two copies of EmbeddedX at the same depth, hence it's
+// ambiguous
+type SEmbeddedTwice struct {
+	embeddedXA
+	embeddedXB
+}
+
+type embeddedPtr struct {
+	X int
+}
+
+type SEmbeddedUnexportedPtr struct {
+	*embeddedPtr
+}
+
 var unmarshalCases = []struct {
 	input    string
 	schema   interface{}
@@ -160,6 +265,60 @@ var unmarshalCases = []struct {
 	{`(X hello)`, &S10{}, &S10{}, true},
 	{`(x hello)`, &S11{}, &S11{}, false},
 	{`(`, S11{}, &S11{}, false},
+	{`(name app) (verbose true) (level debug) (token xyz) (port 80)`, &SEmbedded{},
+		&SEmbedded{CommonOptions{"", true}, &LogOptions{Level: "debug"}, secretOptions{"xyz"}, 80}, true},
+	{`(port 80)`, &SEmbedded{}, &SEmbedded{Port: 80}, true},
+	{`(verbose yes)`, &SEmbeddedShadow{}, &SEmbeddedShadow{Verbose: "yes"}, true},
+	{`(common (name app))`, &SEmbeddedTagged{}, &SEmbeddedTagged{CommonOptions{Name: "app"}}, true},
+	{`(name app)`, &SEmbeddedTagged{}, &SEmbeddedTagged{}, true},
+	{`(X 1)`, &SEmbeddedUnexportedPtr{}, nil, false},
+	{`(X 5)`, &SEmbeddedTwice{}, &SEmbeddedTwice{}, true},
+	{`(Labels hello)`, &S12{}, &S12{"hello"}, true},
+	{`(Labels a b)`, &S12{}, &S12{[]interface{}{"a", "b"}}, true},
+	{`(Labels (a b))`, &S12{}, &S12{[]interface{}{"a", "b"}}, true},
+	{`(Labels ())`, &S12{}, &S12{[]interface{}{}}, true},
+	{`(Labels (env prod) (tags (a b) c))`, &S12{}, &S12{[]interface{}{
+		[]interface{}{"env", "prod"},
+		[]interface{}{"tags", []interface{}{"a", "b"}, "c"},
+	}}, true},
+	{`(Err oops)`, &S13{}, nil, false},
+	{`(IP 10.0.0.1) (Prefix 10.0.0.0/8) (Big 123456789012345678901234567890) (Hosts (::1 localhost))`, &STextual{},
+		&STextual{
+			IP:     net.ParseIP("10.0.0.1"),
+			Prefix: netip.MustParsePrefix("10.0.0.0/8"),
+			Big:    bigInt("123456789012345678901234567890"),
+			Hosts:  map[netip.Addr]string{netip.IPv6Loopback(): "localhost"},
+		}, true},
+	{`(IP 10.0.0)`, &STextual{}, nil, false},
+	{`(Prefix (10.0.0.0/8))`, &STextual{}, nil, false},
+	{`(IP 10 0 0 1)`, &STextual{}, nil, false},
+	{`(Big 0x)`, &STextual{}, nil, false},
+	{`(Timeout 1m30s) (Deadline 2017-03-01T12:00:00Z) (Memory 256MiB) (Disk 1.5GB)`, &SLimits{},
+		&SLimits{
+			Timeout:  90 * time.Second,
+			Deadline: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+			Memory:   256 * MiB,
+			Disk:     byteSizePtr(1500 * MB),
+		}, true},
+	{`(Timeout 30)`, &SLimits{}, nil, false},
+	{`(Timeout (30s))`, &SLimits{}, nil, false},
+	{`(Deadline 2017-03-01)`, &SLimits{}, nil, false},
+	{`(Memory 256M)`, &SLimits{}, nil, false},
+	{`(id app) (cmd run)`, &SDeployment{},
+		&SDeployment{ID: "app", Cmd: "run", Port: 8080, Args: []string{"-v", "a, b"}, Timeout: 30 * time.Second}, true},
+	{`(id app) (cmd run) (port 80) (args x) (labels (a b))`, &SDeployment{},
+		&SDeployment{ID: "app", Cmd: "run", Port: 80, Args: []string{"x"}, Timeout: 30 * time.Second, Labels: map[string]string{"a": "b"}}, true},
+	{`(cmd run)`, &SDeployment{}, nil, false},
+	{``, &SBadDefault{}, nil, false},
+}
+
+func byteSizePtr(b ByteSize) *ByteSize {
+	return &b
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
 }
 
 func prettyPrintAsJson(v interface{}) string {
@@ -410,3 +569,123 @@ func TestMarathonConfig(t *testing.T) {
 		t.Error("the result doesn't meet expectations")
 	}
 }
+
+func BenchmarkUnmarshalMarathon(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var config MarathonConfig
+		if err := Unmarshal(data, &config); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Same as above, but without parsing.
+func BenchmarkUnmarshalMarathonTree(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		b.Fatal(err)
+	}
+	tree, err := Parse(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var config MarathonConfig
+		var d decodeState
+		if err := d.unmarshalValue(tree, reflect.ValueOf(&config).Elem()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var unmarshalErrorCases = []struct {
+	input  string
+	schema interface{}
+	path   string
+	value  string
+	pos    Pos
+	msg    string
+}{
+	{"(container (docker (portMappings\n\t((hostPort 1))\n\t((hostPort abc)))))", &MarathonConfig{},
+		"container.docker.portMappings[1].hostPort", "abc", Pos{61, 3, 13},
+		`3:13: cannot unmarshal "abc" into container.docker.portMappings[1].hostPort of type int: node is not an integer`},
+	{"(env (PATH (a b)))", &MarathonConfig{}, "env.PATH", "", Pos{11, 1, 12},
+		`1:12: cannot unmarshal into env.PATH of type string: scalar node expected`},
+	{"(Ints 1 x)", &S7{}, "Ints[1]", "x", Pos{8, 1, 9},
+		`1:9: cannot unmarshal "x" into Ints[1] of type int: node is not an integer`},
+	{"(Map (edit true) view)", &S8{}, "Map", "view", Pos{17, 1, 18},
+		`1:18: cannot unmarshal "view" into Map of type map[string]bool: map element must be represented via (key value...) list`},
+	{"(IP 10 0 0 1)", &STextual{}, "IP", "", Pos{4, 1, 5},
+		`1:5: cannot unmarshal into IP of type net.IP: scalar node expected`},
+	{"(x hello)", &S11{}, "x", "x", Pos{1, 1, 2},
+		`1:2: cannot unmarshal "x" into x of type uint8: writing to unexported field`},
+	{"(A 1)", &SChan{}, "A", "1", Pos{3, 1, 4},
+		`1:4: cannot unmarshal "1" into A of type chan int: unsupported type`},
+	{"1 2", &Vec3{}, "", "", Pos{0, 1, 1},
+		`1:1: cannot unmarshal into value of type sx.Vec3: expected a list of 3 floating point elements`},
+	{"(port 80)", &SDeployment{}, "", "", Pos{0, 1, 1},
+		`1:1: cannot unmarshal into value of type sx.SDeployment: missing required fields: id, cmd`},
+	{"(deploy (port 80))", &struct {
+		Deploy SDeployment `sx:"deploy"`
+	}{}, "deploy", "", Pos{8, 1, 9},
+		`1:9: cannot unmarshal into deploy of type sx.SDeployment: missing required fields: id, cmd`},
+	{"", &SBadDefault{}, "port", "http", Pos{},
+		`cannot unmarshal "http" into port of type int: invalid default value: node is not an integer`},
+	{"(other 1)", &SBadDefaultSyntax{}, "name", "(a", Pos{},
+		`cannot unmarshal "(a" into name of type string: invalid default value: 1:3: unexpected eof when parsing a list opened at 1:1`},
+}
+
+func TestUnmarshalError(t *testing.T) {
+	for i, c := range unmarshalErrorCases {
+		err := Unmarshal([]byte(c.input), c.schema)
+		e, ok := err.(*UnmarshalError)
+		if !ok {
+			t.Errorf("case %d, expected *UnmarshalError, got: %v", i, err)
+			continue
+		}
+		if e.Path != c.path || e.Value != c.value || e.Pos != c.pos {
+			t.Errorf("case %d, got path %q, value %q, position %s (%d)", i, e.Path, e.Value, e.Pos, e.Pos.Offset)
+		}
+		if e.Error() != c.msg {
+			t.Errorf("case %d, got message:\n%s\nexpected:\n%s", i, e, c.msg)
+		}
+	}
+}
+
+func TestUnmarshalWithOptions(t *testing.T) {
+	input := []byte(`(name nsf) (email no.smile.face@gmail.com)`)
+	var v SValidSimple
+	if err := UnmarshalWithOptions(input, &v, ParseOptions{MaxNodes: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if v != (SValidSimple{"nsf", "no.smile.face@gmail.com"}) {
+		t.Errorf("got %v", v)
+	}
+
+	err := UnmarshalWithOptions(input, &v, ParseOptions{MaxValueBytes: 10})
+	expected := &LimitError{"MaxValueBytes", 10, Pos{18, 1, 19}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("got error: %v, expected: %v", err, expected)
+	}
+}
+
+func TestStructPlanDefaults(t *testing.T) {
+	// default values are parsed once, when the plan is built
+	plan := cachedStructPlan(reflect.TypeOf(SDeployment{}))
+	for _, f := range plan.fields {
+		if f.hasDefault && (f.defTree == nil || f.defErr != nil) {
+			t.Errorf("field %s, default value is not parsed: %v", f.name, f.defErr)
+		}
+	}
+	plan = cachedStructPlan(reflect.TypeOf(SBadDefaultSyntax{}))
+	if _, ok := plan.fields[0].defErr.(*SyntaxError); !ok {
+		t.Errorf("expected a syntax error, got: %v", plan.fields[0].defErr)
+	}
+}
@@ -0,0 +1,138 @@
+package sx
+
+import (
+	"errors"
+	"strconv"
+)
+
+//----------------------------------------------------------------------------
+// navigation
+//----------------------------------------------------------------------------
+
+// A keyed list follows the (key value...) convention: it's a list which
+// starts with a scalar.
+func (n *Node) isKeyed() bool {
+	return len(n.List) != 0 && n.List[0].IsScalar()
+}
+
+// Key returns the first element of a (key value...) list. It returns an empty
+// string if the node is a scalar or a list which doesn't start with a scalar.
+func (n *Node) Key() string {
+	if !n.isKeyed() {
+		return ""
+	}
+	return n.List[0].Value
+}
+
+// Args returns the values of a (key value...) list, everything after the key.
+// It returns nil if the node is a scalar or a list which doesn't start with a
+// scalar.
+func (n *Node) Args() []Node {
+	if !n.isKeyed() {
+		return nil
+	}
+	return n.List[1:]
+}
+
+// Lookup follows a path of keys through nested (key value...) lists and
+// returns the values of the last one, e.g. Lookup(nodes, "docker", "image").
+// The first list with a matching key is used at every level. Lists are found
+// the same way Unmarshal finds struct fields, thus (docker ((image x))) works
+// the same as (docker (image x)).
+func Lookup(nodes []Node, keys ...string) ([]Node, bool) {
+	for _, key := range keys {
+		var values []Node
+		found := false
+		for _, n := range indirectMap(nodes) {
+			if n.isKeyed() && n.List[0].Value == key {
+				values, found = n.List[1:], true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		nodes = values
+	}
+	return nodes, true
+}
+
+// SkipList can be returned by a WalkFunc to skip elements of the current
+// list. Returned for a scalar, it's ignored.
+var SkipList = errors.New("skip this list")
+
+// SkipAll can be returned by a WalkFunc to stop walking, Walk returns nil in
+// this case.
+var SkipAll = errors.New("skip everything and stop the walk")
+
+// WalkFunc is called by Walk for every node. The node can be modified in
+// place.
+type WalkFunc func(path string, n *Node) error
+
+// Appends a key to a path in the Query syntax.
+func appendPathKey(path, key string) string {
+	quote := key == ""
+	for i := 0; i < len(key); i++ {
+		if !isQueryKey(key[i]) {
+			quote = true
+			break
+		}
+	}
+	if quote {
+		key = string(appendStringLiteral(nil, key))
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func walkNodes(path string, nodes []Node, fn WalkFunc) error {
+	for i := range nodes {
+		n := &nodes[i]
+		var npath string
+		if n.isKeyed() {
+			npath = appendPathKey(path, n.List[0].Value)
+		} else {
+			npath = path + "[" + strconv.Itoa(i) + "]"
+		}
+		if err := walkNode(npath, n, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkNode(path string, n *Node, fn WalkFunc) error {
+	err := fn(path, n)
+	if err == SkipList {
+		return nil
+	}
+	if err != nil || n.IsScalar() {
+		return err
+	}
+	if n.isKeyed() {
+		// keys aren't visited, values are indexed from zero
+		return walkNodes(path, n.List[1:], fn)
+	}
+	return walkNodes(path, n.List, fn)
+}
+
+// Walk calls 'fn' for every node in depth-first order, a list is visited
+// before its elements. Keys of (key value...) lists are not visited on their
+// own.
+//
+// The path of a node uses the Query syntax: a (key value...) list is named by
+// its key, other nodes by their index in the enclosing list or values, e.g.
+// "container.docker.portMappings[0].hostPort" or "ports[1]".
+//
+// If 'fn' returns SkipList, elements of the list are skipped. If it returns
+// SkipAll, Walk stops and returns nil. Any other error stops Walk and is
+// returned as is.
+func Walk(nodes []Node, fn WalkFunc) error {
+	err := walkNodes("", nodes, fn)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package sx
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyArgs(t *testing.T) {
+	nodes, err := Parse([]byte(`(ports 8080 9000) (flag) ((a 1)) () x ("" y)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		key  string
+		args string
+	}{
+		{"ports", "8080\n9000\n"},
+		{"flag", ""},
+		{"", ""},
+		{"", ""},
+		{"", ""},
+		{"", "y\n"},
+	}
+	for i, e := range expected {
+		if k := nodes[i].Key(); k != e.key {
+			t.Errorf("case %d, got key %q, expected %q", i, k, e.key)
+		}
+		if args := string(Print(nodes[i].Args())); args != e.args {
+			t.Errorf("case %d, got args %q, expected %q", i, args, e.args)
+		}
+	}
+}
+
+var lookupCases = []struct {
+	input    string
+	keys     []string
+	expected string // printed result
+	found    bool
+}{
+	{"(container (docker (image group/image)))", []string{"container", "docker", "image"}, "group/image\n", true},
+	{"(container (docker ((image group/image))))", []string{"container", "docker", "image"}, "group/image\n", true},
+	{"(ports 8080 9000) (ports 1)", []string{"ports"}, "8080\n9000\n", true},
+	{"(a (b))", []string{"a", "b"}, "", true},
+	{"(a 1)", nil, "(a 1)\n", true},
+	{"(a (b 1))", []string{"a", "c"}, "", false},
+	{"(a 1)", []string{"a", "1"}, "", false},
+	{"(a (1 2))", []string{"a", "1"}, "2\n", true},
+}
+
+func TestLookup(t *testing.T) {
+	for i, c := range lookupCases {
+		nodes, err := Parse([]byte(c.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, found := Lookup(nodes, c.keys...)
+		if found != c.found {
+			t.Errorf("case %d, got found %v, expected %v", i, found, c.found)
+			continue
+		}
+		if out := string(Print(result)); out != c.expected {
+			t.Errorf("case %d, got:\n%s\nexpected:\n%s", i, out, c.expected)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	input := "(id app) (ports 8080 9000) (container (docker (image x) (portMappings ((hostPort 0)) ((hostPort 1))))) (a.b 1) ((x) 3)"
+	nodes, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	err = Walk(nodes, func(path string, n *Node) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"id", "id[0]",
+		"ports", "ports[0]", "ports[1]",
+		"container", "container.docker", "container.docker.image", "container.docker.image[0]",
+		"container.docker.portMappings",
+		"container.docker.portMappings[0]", "container.docker.portMappings[0].hostPort", "container.docker.portMappings[0].hostPort[0]",
+		"container.docker.portMappings[1]", "container.docker.portMappings[1].hostPort", "container.docker.portMappings[1].hostPort[0]",
+		`"a.b"`, `"a.b"[0]`,
+		"[4]", "[4].x", "[4][1]",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got:\n%s\nexpected:\n%s", strings.Join(paths, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// paths of scalars select them in Query
+	Walk(nodes, func(path string, n *Node) error {
+		if !n.IsScalar() {
+			return nil
+		}
+		result, err := Query(nodes, path)
+		if err != nil || len(result) != 1 || result[0].Value != n.Value {
+			t.Errorf("query %q, got %+v, %v, expected %q", path, result, err, n.Value)
+		}
+		return nil
+	})
+
+	paths = nil
+	Walk(nodes, func(path string, n *Node) error {
+		paths = append(paths, path)
+		if n.Key() == "container" {
+			return SkipList
+		}
+		if n.Key() == "a.b" {
+			return SkipAll
+		}
+		return nil
+	})
+	expected = []string{"id", "id[0]", "ports", "ports[0]", "ports[1]", "container", `"a.b"`}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got:\n%s\nexpected:\n%s", strings.Join(paths, "\n"), strings.Join(expected, "\n"))
+	}
+
+	errStop := errors.New("stop")
+	err = Walk(nodes, func(path string, n *Node) error {
+		if path == "ports[1]" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("expected the error to be returned, got: %v", err)
+	}
+
+	// nodes can be modified in place
+	Walk(nodes, func(path string, n *Node) error {
+		if path == "container.docker.image[0]" {
+			n.Value = "y"
+		}
+		return nil
+	})
+	if image, _ := Lookup(nodes, "container", "docker", "image"); image[0].Value != "y" {
+		t.Errorf("expected the image to be modified, got: %s", image[0].Value)
+	}
+}
+
+func BenchmarkWalkMarathon(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/marathon.sx")
+	if err != nil {
+		b.Fatal(err)
+	}
+	nodes, err := Parse(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Walk(nodes, func(path string, n *Node) error { return nil })
+	}
+}